@@ -2,9 +2,18 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/lmittmann/tint"
 	"github.com/mashiro/demod/internal/demod"
@@ -30,6 +39,20 @@ func main() {
 				Value:   "text",
 				Usage:   "Log format (text, json)",
 			},
+			&cli.StringFlag{
+				Name:    "cache-dir",
+				Usage:   "Directory for cached repository mirrors (default: cache_dir in config, or demod under the user cache dir)",
+				Sources: cli.EnvVars("DEMOD_CACHE_DIR"),
+			},
+			&cli.IntFlag{
+				Name:    "jobs",
+				Aliases: []string{"j"},
+				Usage:   fmt.Sprintf("Number of modules processed at once (default: jobs in config, or %d)", demod.DefaultJobs),
+			},
+			&cli.IntFlag{
+				Name:  "jobs-per-host",
+				Usage: "Number of modules processed at once per git host (default: jobs_per_host in config, or unlimited)",
+			},
 			&cli.BoolFlag{
 				Name:  "no-color",
 				Usage: "Disable colored output",
@@ -57,29 +80,468 @@ func main() {
 						Name:  "dry-run",
 						Usage: "Show what would be synced without making changes",
 					},
+					&cli.BoolFlag{
+						Name:    "frozen",
+						Aliases: []string{"locked"},
+						Usage:   "Fail if the lock file is missing or out of date instead of updating it",
+					},
+					&cli.BoolFlag{
+						Name:  "keep-going",
+						Usage: "Process every module even if some fail, and report all failures at the end",
+					},
+					&cli.BoolFlag{
+						Name:  "offline",
+						Usage: "Sync from the cache only, without network access, and list every module missing from it",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					cfgPath := cmd.Root().String("config")
+					cfg, err := demod.Load(cfgPath)
+					if err != nil {
+						return err
+					}
+					opts, err := syncOptions(cmd, cfgPath, cfg)
+					if err != nil {
+						return err
+					}
+					opts.DryRun = cmd.Bool("dry-run")
+					opts.Frozen = cmd.Bool("frozen")
+					opts.KeepGoing = cmd.Bool("keep-going")
+					opts.Offline = cmd.Bool("offline")
+					return demod.SyncAll(ctx, cfg, opts)
+				},
+			},
+			{
+				Name:  "check",
+				Usage: "Check that vendored files are up to date without modifying them",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "keep-going",
+						Usage: "Process every module even if some fail, and report all failures at the end",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					cfgPath := cmd.Root().String("config")
+					cfg, err := demod.Load(cfgPath)
+					if err != nil {
+						return err
+					}
+					opts, err := syncOptions(cmd, cfgPath, cfg)
+					if err != nil {
+						return err
+					}
+					opts.KeepGoing = cmd.Bool("keep-going")
+					drifts, err := demod.Check(ctx, cfg, opts)
+					if len(drifts) > 0 {
+						printDrifts(drifts)
+					}
+					if err != nil {
+						return err
+					}
+					if len(drifts) > 0 {
+						return fmt.Errorf("%d module(s) out of date", len(drifts))
+					}
+					return nil
+				},
+			},
+			{
+				Name:  "verify",
+				Usage: "Verify vendored files against the hashes recorded at sync time, without network access",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					cfgPath := cmd.Root().String("config")
+					cfg, err := demod.Load(cfgPath)
+					if err != nil {
+						return err
+					}
+					mismatches, err := demod.Verify(cfg, localOptions(cmd, cfgPath, cfg))
+					if err != nil {
+						return err
+					}
+					if len(mismatches) > 0 {
+						printMismatches(mismatches)
+						return fmt.Errorf("%d module(s) failed verification", len(mismatches))
+					}
+					return nil
+				},
+			},
+			{
+				Name:      "update",
+				Usage:     "Re-resolve module revisions, refresh the lock file and sync",
+				ArgsUsage: "[module...]",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "dry-run",
+						Usage: "Show what would be updated without making changes",
+					},
+					&cli.BoolFlag{
+						Name:  "keep-going",
+						Usage: "Process every module even if some fail, and report all failures at the end",
+					},
+				},
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					cfgPath := cmd.Root().String("config")
+					cfg, err := demod.Load(cfgPath)
+					if err != nil {
+						return err
+					}
+					opts, err := syncOptions(cmd, cfgPath, cfg)
+					if err != nil {
+						return err
+					}
+					opts.DryRun = cmd.Bool("dry-run")
+					opts.KeepGoing = cmd.Bool("keep-going")
+					changes, err := demod.Update(ctx, cfg, cmd.Args().Slice(), opts)
+					printLockChanges(changes)
+					return err
+				},
+			},
+			{
+				Name:  "outdated",
+				Usage: "Show modules whose locked commit is behind upstream (prints JSON with --format json)",
+				Action: func(ctx context.Context, cmd *cli.Command) error {
+					cfgPath := cmd.Root().String("config")
+					cfg, err := demod.Load(cfgPath)
+					if err != nil {
+						return err
+					}
+					opts, err := syncOptions(cmd, cfgPath, cfg)
+					if err != nil {
+						return err
+					}
+					statuses, err := demod.Outdated(ctx, cfg, opts)
+					if err != nil {
+						return err
+					}
+					if cmd.Root().String("format") == "json" {
+						enc := json.NewEncoder(os.Stdout)
+						enc.SetIndent("", "  ")
+						return enc.Encode(statuses)
+					}
+					printModuleStatuses(statuses)
+					return nil
+				},
+			},
+			{
+				Name:      "changelog",
+				Usage:     "List the upstream commits and vendored file changes of a module between two revisions (prints JSON with --format json)",
+				ArgsUsage: "<module>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "from",
+						Usage: "Revision to compare from (default: the locked commit)",
+					},
+					&cli.StringFlag{
+						Name:  "to",
+						Usage: "Revision to compare to (default: the latest upstream commit, as shown by outdated)",
+					},
 				},
 				Action: func(ctx context.Context, cmd *cli.Command) error {
+					if cmd.Args().Len() != 1 {
+						return errors.New("expected exactly one module name")
+					}
 					cfgPath := cmd.Root().String("config")
 					cfg, err := demod.Load(cfgPath)
 					if err != nil {
 						return err
 					}
-					logger := buildLogger(cmd.Root().String("format"), cmd.Root().Bool("no-color"), cmd.Root().Bool("verbose"))
-					return demod.SyncAll(cfg, demod.SyncOptions{
-						DryRun: cmd.Bool("dry-run"),
-						Logger: logger,
-					})
+					opts, err := syncOptions(cmd, cfgPath, cfg)
+					if err != nil {
+						return err
+					}
+					changelog, err := demod.Changelog(ctx, cfg, cmd.Args().First(), cmd.String("from"), cmd.String("to"), opts)
+					if err != nil {
+						return err
+					}
+					if cmd.Root().String("format") == "json" {
+						enc := json.NewEncoder(os.Stdout)
+						enc.SetIndent("", "  ")
+						return enc.Encode(changelog)
+					}
+					printChangelog(changelog)
+					return nil
+				},
+			},
+			{
+				Name:  "cache",
+				Usage: "Manage the repository cache",
+				Commands: []*cli.Command{
+					{
+						Name:  "list",
+						Usage: "List cached repositories",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							dir, err := cacheDirFromConfig(cmd)
+							if err != nil {
+								return err
+							}
+							repos, err := demod.ListCache(dir)
+							if err != nil {
+								return err
+							}
+							printCachedRepos(repos)
+							return nil
+						},
+					},
+					{
+						Name:  "prune",
+						Usage: "Remove cached repositories that no module in the config uses",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							cfg, err := demod.Load(cmd.Root().String("config"))
+							if err != nil {
+								return err
+							}
+							dir, err := resolveCacheDir(cmd, cfg)
+							if err != nil {
+								return err
+							}
+							pruned, err := demod.PruneCache(ctx, cacheLogger(cmd), dir, cfg)
+							if err != nil {
+								return err
+							}
+							for _, r := range pruned {
+								fmt.Printf("removed %s\n", r.Repo)
+							}
+							return nil
+						},
+					},
+					{
+						Name:  "clean",
+						Usage: "Remove the whole cache",
+						Action: func(ctx context.Context, cmd *cli.Command) error {
+							dir, err := cacheDirFromConfig(cmd)
+							if err != nil {
+								return err
+							}
+							return demod.CleanCache(ctx, cacheLogger(cmd), dir)
+						},
+					},
 				},
 			},
 		},
 	}
 
-	if err := app.Run(context.Background(), os.Args); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		// Restore the default behavior so a second signal terminates immediately.
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := app.Run(ctx, os.Args); err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Fprintln(os.Stderr, "interrupted")
+			os.Exit(130)
+		}
+		var failed demod.ModuleErrors
+		if errors.As(err, &failed) {
+			printModuleErrors(failed)
+			// Errors joined with the module errors, such as a failure to save the lock.
+			for _, e := range joinedErrors(err) {
+				if !errors.As(e, new(demod.ModuleErrors)) {
+					fmt.Fprintf(os.Stderr, "error: %v\n", e)
+				}
+			}
+			fmt.Fprintf(os.Stderr, "error: %d module(s) failed\n", len(failed))
+			os.Exit(1)
+		}
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
+// syncOptions returns the options shared by the commands that operate on the modules in cfg.
+func syncOptions(cmd *cli.Command, cfgPath string, cfg *demod.Config) (demod.SyncOptions, error) {
+	cacheDir, err := resolveCacheDir(cmd, cfg)
+	if err != nil {
+		return demod.SyncOptions{}, err
+	}
+	opts := localOptions(cmd, cfgPath, cfg)
+	opts.CacheDir = cacheDir
+	return opts, nil
+}
+
+// localOptions is like syncOptions without the cache dir, for the commands that only read the
+// working tree and must not depend on HOME or XDG_CACHE_HOME.
+func localOptions(cmd *cli.Command, cfgPath string, cfg *demod.Config) demod.SyncOptions {
+	opts := demod.SyncOptions{
+		LockFile:    demod.LockPath(cfgPath),
+		Jobs:        cfg.Jobs,
+		JobsPerHost: cfg.JobsPerHost,
+		Retry:       cfg.Retry,
+		Logger:      buildLogger(cmd.Root().String("format"), cmd.Root().Bool("no-color"), cmd.Root().Bool("verbose")),
+	}
+	if cmd.Root().IsSet("jobs") {
+		opts.Jobs = int(cmd.Root().Int("jobs"))
+	}
+	if cmd.Root().IsSet("jobs-per-host") {
+		opts.JobsPerHost = int(cmd.Root().Int("jobs-per-host"))
+	}
+	return opts
+}
+
+// cacheLogger returns the logger of the cache commands, which only log while waiting for a mirror.
+func cacheLogger(cmd *cli.Command) *slog.Logger {
+	return buildLogger(cmd.Root().String("format"), cmd.Root().Bool("no-color"), cmd.Root().Bool("verbose"))
+}
+
+// resolveCacheDir returns the cache directory from the --cache-dir flag, the config or the default, in that order.
+func resolveCacheDir(cmd *cli.Command, cfg *demod.Config) (string, error) {
+	if dir := cmd.Root().String("cache-dir"); dir != "" {
+		return dir, nil
+	}
+	if cfg != nil && cfg.CacheDir != "" {
+		return cfg.CacheDir, nil
+	}
+	return demod.DefaultCacheDir()
+}
+
+// cacheDirFromConfig is like resolveCacheDir but does not require the config file to exist.
+func cacheDirFromConfig(cmd *cli.Command) (string, error) {
+	cfg, err := demod.Load(cmd.Root().String("config"))
+	if errors.Is(err, fs.ErrNotExist) {
+		cfg = nil
+	} else if err != nil {
+		return "", err
+	}
+	return resolveCacheDir(cmd, cfg)
+}
+
+func printCachedRepos(repos []demod.CachedRepo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "REPO\tSIZE\tLAST USED")
+	for _, r := range repos {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", r.Repo, formatSize(r.Size), r.LastUsed.Format(time.DateTime))
+	}
+	_ = w.Flush()
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// printModuleErrors prints every failed module with the stage that failed and its error,
+// including the output of git.
+// joinedErrors returns the errors joined into err by errors.Join, or err itself.
+func joinedErrors(err error) []error {
+	if _, ok := err.(demod.ModuleErrors); ok {
+		return []error{err}
+	}
+	if j, ok := err.(interface{ Unwrap() []error }); ok {
+		return j.Unwrap()
+	}
+	return []error{err}
+}
+
+func printModuleErrors(errs demod.ModuleErrors) {
+	fmt.Fprintln(os.Stderr, "failed modules:")
+	for _, e := range errs {
+		stage := e.Stage
+		if stage == "" {
+			stage = "-"
+		}
+		fmt.Fprintf(os.Stderr, "  %s (%s)\n", e.Module, stage)
+		for line := range strings.Lines(strings.TrimSpace(e.Err.Error())) {
+			if line = strings.TrimRight(line, " \n"); line != "" {
+				line = "      " + line
+			}
+			fmt.Fprintln(os.Stderr, line)
+		}
+	}
+}
+
+func printLockChanges(changes []demod.LockChange) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, c := range changes {
+		to := c.To
+		if c.Tag != "" {
+			to += " (" + c.Tag + ")"
+		}
+		switch {
+		case c.From == "":
+			_, _ = fmt.Fprintf(w, "%s\t(new) → %s\n", c.Name, to)
+		case c.From == c.To:
+			_, _ = fmt.Fprintf(w, "%s\t%s (unchanged)\n", c.Name, to)
+		default:
+			_, _ = fmt.Fprintf(w, "%s\t%s → %s\n", c.Name, c.From, to)
+		}
+	}
+	_ = w.Flush()
+}
+
+func printModuleStatuses(statuses []demod.ModuleStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "MODULE\tCURRENT\tLATEST\tBEHIND")
+	for _, s := range statuses {
+		current, behind := shortCommit(s.Current), strconv.Itoa(s.Behind)
+		if s.Current == "" {
+			current, behind = "(not locked)", "-"
+		}
+		latest := shortCommit(s.Latest)
+		if s.LatestTag != "" {
+			latest += " (" + s.LatestTag + ")"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Module, current, latest, behind)
+	}
+	_ = w.Flush()
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
+
+func printChangelog(c *demod.ModuleChangelog) {
+	fmt.Printf("%s %s..%s\n", c.Module, shortCommit(c.From), shortCommit(c.To))
+	fmt.Printf("\n%d commit(s)\n", len(c.Commits))
+	for _, commit := range c.Commits {
+		fmt.Printf("  %s  %s  %s (%s)\n", shortCommit(commit.Commit), commit.Date.Format(time.DateOnly), commit.Subject, commit.Author)
+	}
+	fmt.Printf("\n%d file(s) changed\n", len(c.Files))
+	for _, f := range c.Files {
+		fmt.Printf("  %-9s %s\n", f.Status, f.Path)
+	}
+}
+
+func printDrifts(drifts []demod.Drift) {
+	for _, d := range drifts {
+		fmt.Println(d.Module)
+		for _, f := range d.Added {
+			fmt.Printf("  added     %s\n", f)
+		}
+		for _, f := range d.Removed {
+			fmt.Printf("  removed   %s\n", f)
+		}
+		for _, f := range d.Modified {
+			fmt.Printf("  modified  %s\n", f)
+		}
+	}
+}
+
+func printMismatches(mismatches []demod.Mismatch) {
+	for _, m := range mismatches {
+		fmt.Println(m.Module)
+		for _, p := range m.Paths {
+			fmt.Printf("  lock mismatch  %s\n", p)
+		}
+		for _, f := range m.Modified {
+			fmt.Printf("  modified       %s\n", f)
+		}
+		for _, f := range m.Missing {
+			fmt.Printf("  missing        %s\n", f)
+		}
+	}
+}
+
 func buildLogger(format string, noColor, verbose bool) *slog.Logger {
 	var level slog.Level
 	if verbose {
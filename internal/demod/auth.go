@@ -0,0 +1,106 @@
+package demod
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// DefaultTokenUser is the user name sent with Auth.TokenEnv when Auth.TokenUser is not set.
+// GitHub, GitLab and Gitea accept any user name with an access token.
+const DefaultTokenUser = "x-access-token"
+
+// Auth configures the credentials git uses to fetch a module, instead of the ambient ones.
+// Secrets are never put into repository URLs, git arguments or logs.
+type Auth struct {
+	// TokenEnv names the environment variable holding an HTTPS access token.
+	TokenEnv string `toml:"token_env"`
+	// TokenUser is the user name sent with the token. Defaults to DefaultTokenUser.
+	TokenUser string `toml:"token_user"`
+	// SSHKey is the private key used for SSH URLs.
+	SSHKey string `toml:"ssh_key"`
+	// CredentialHelper is a git credential helper, as in the credential.helper setting of git-config(1).
+	CredentialHelper string `toml:"credential_helper"`
+}
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validate checks that a is complete and consistent, and can be used for repo.
+func (a *Auth) validate(repo string) error {
+	if a.TokenEnv == "" && a.SSHKey == "" && a.CredentialHelper == "" {
+		return fmt.Errorf("auth requires token_env, ssh_key or credential_helper")
+	}
+	if a.TokenEnv != "" && a.CredentialHelper != "" {
+		return fmt.Errorf("auth: token_env and credential_helper are mutually exclusive")
+	}
+	if a.TokenEnv != "" && !envNamePattern.MatchString(a.TokenEnv) {
+		return fmt.Errorf("auth: invalid token_env %q: not an environment variable name", a.TokenEnv)
+	}
+	if a.TokenUser != "" && a.TokenEnv == "" {
+		return fmt.Errorf("auth: token_user requires token_env")
+	}
+	if _, ok := credentialURL(repo); a.TokenEnv != "" && !ok {
+		return fmt.Errorf("auth: token_env requires an http or https repo")
+	}
+	return nil
+}
+
+// credentialURL returns the scheme and host of the http or https URL repo, which git matches
+// credential settings against.
+func credentialURL(repo string) (string, bool) {
+	u, err := url.Parse(repo)
+	if err != nil || u.Host == "" || u.Scheme != "http" && u.Scheme != "https" {
+		return "", false
+	}
+	return u.Scheme + "://" + u.Host, true
+}
+
+// gitContext returns a context in which git authenticates to repo with the credentials configured by a.
+// A nil a leaves the ambient credentials in place.
+//
+// The token is read by a credential helper from the environment variable named by TokenEnv,
+// so only the name of the variable is ever passed to git. The helper only answers for the host
+// of repo: mirrors, rewritten URLs and redirects to other hosts never see the token.
+func (a *Auth) gitContext(ctx context.Context, repo string) (context.Context, error) {
+	if a == nil {
+		return ctx, nil
+	}
+	// Fail instead of waiting for a password prompt when the credentials are rejected.
+	ctx = withGitEnv(ctx, "GIT_TERMINAL_PROMPT=0")
+	if a.SSHKey != "" {
+		ctx = withGitEnv(ctx, "GIT_SSH_COMMAND=ssh -i "+shellQuote(a.SSHKey)+" -o IdentitiesOnly=yes")
+	}
+	switch {
+	case a.TokenEnv != "":
+		if os.Getenv(a.TokenEnv) == "" {
+			return nil, fmt.Errorf("auth: environment variable %s is not set", a.TokenEnv)
+		}
+		user := a.TokenUser
+		if user == "" {
+			user = DefaultTokenUser
+		}
+		scope, ok := credentialURL(repo)
+		if !ok {
+			return nil, fmt.Errorf("auth: token_env requires an http or https repo")
+		}
+		helper := fmt.Sprintf(`!f() { test "$1" = get || return 0; echo %s; echo "password=$%s"; }; f`, shellQuote("username="+user), a.TokenEnv)
+		ctx = withCredentialHelper(ctx, "credential."+scope+".helper", helper)
+	case a.CredentialHelper != "":
+		ctx = withCredentialHelper(ctx, "credential.helper", a.CredentialHelper)
+	}
+	return ctx, nil
+}
+
+// withCredentialHelper returns a context in which helper, set as the credential helper config
+// key, is the only credential helper of git.
+func withCredentialHelper(ctx context.Context, key, helper string) context.Context {
+	return withGitConfig(withGitConfig(ctx, "credential.helper", ""), key, helper)
+}
+
+// shellQuote quotes s as a single word for sh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,166 @@
+package demod
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAuthGitContext(t *testing.T) {
+	t.Run("nil keeps the ambient credentials", func(t *testing.T) {
+		var a *Auth
+		ctx, err := a.gitContext(t.Context(), testPrivateRepo)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if env := gitCommandEnv(ctx); len(env) != 0 {
+			t.Errorf("env = %v, want none", env)
+		}
+	})
+
+	t.Run("token is read from the environment by the credential helper", func(t *testing.T) {
+		const secret = "s3cr3t-t0ken"
+		t.Setenv("DEMOD_TEST_TOKEN", secret)
+		ctx, err := (&Auth{TokenEnv: "DEMOD_TEST_TOKEN"}).gitContext(t.Context(), testPrivateRepo)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		env := gitCommandEnv(ctx)
+		if slices.ContainsFunc(env, func(e string) bool { return strings.Contains(e, secret) }) {
+			t.Errorf("env contains the token: %v", env)
+		}
+		out, err := credentialFill(ctx, "example.com")
+		if err != nil {
+			t.Fatalf("git credential fill: %v\n%s", err, out)
+		}
+		for _, want := range []string{"username=" + DefaultTokenUser, "password=" + secret} {
+			if !strings.Contains(out, want+"\n") {
+				t.Errorf("credential fill = %q, want %q", out, want)
+			}
+		}
+	})
+
+	t.Run("token user", func(t *testing.T) {
+		t.Setenv("DEMOD_TEST_TOKEN", "token")
+		ctx, err := (&Auth{TokenEnv: "DEMOD_TEST_TOKEN", TokenUser: "o'brien"}).gitContext(t.Context(), testPrivateRepo)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if out, err := credentialFill(ctx, "example.com"); err != nil || !strings.Contains(out, "username=o'brien\n") {
+			t.Errorf("credential fill = %q, want username o'brien", out)
+		}
+	})
+
+	t.Run("token is only sent to the host of the repo", func(t *testing.T) {
+		const secret = "s3cr3t-t0ken"
+		t.Setenv("DEMOD_TEST_TOKEN", secret)
+		ctx, err := (&Auth{TokenEnv: "DEMOD_TEST_TOKEN"}).gitContext(t.Context(), testPrivateRepo)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// A public fallback mirror, or a rewrite or redirect to another host.
+		for _, host := range []string{"mirror.example.org", "example.com.evil.test"} {
+			out, err := credentialFill(ctx, host)
+			if err == nil || strings.Contains(out, secret) {
+				t.Errorf("credential fill for %s = %q, %v, want an error without the token", host, out, err)
+			}
+		}
+	})
+
+	t.Run("token requires an http repo", func(t *testing.T) {
+		t.Setenv("DEMOD_TEST_TOKEN", "token")
+		if _, err := (&Auth{TokenEnv: "DEMOD_TEST_TOKEN"}).gitContext(t.Context(), "git@example.com:org/private.git"); err == nil {
+			t.Fatal("expected error for token auth over SSH")
+		}
+	})
+
+	t.Run("unset token variable", func(t *testing.T) {
+		t.Setenv("DEMOD_TEST_TOKEN", "")
+		_, err := (&Auth{TokenEnv: "DEMOD_TEST_TOKEN"}).gitContext(t.Context(), testPrivateRepo)
+		if err == nil || !strings.Contains(err.Error(), "DEMOD_TEST_TOKEN") {
+			t.Fatalf("err = %v, want an error naming DEMOD_TEST_TOKEN", err)
+		}
+	})
+
+	t.Run("credential helper replaces the configured helpers", func(t *testing.T) {
+		helper := `!f() { echo username=helper; echo password=from-helper; }; f`
+		ctx, err := (&Auth{CredentialHelper: helper}).gitContext(t.Context(), testPrivateRepo)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if out, err := credentialFill(ctx, "example.com"); err != nil || !strings.Contains(out, "password=from-helper\n") {
+			t.Errorf("credential fill = %q, want the password of the helper", out)
+		}
+	})
+
+	t.Run("ssh key", func(t *testing.T) {
+		ctx, err := (&Auth{SSHKey: "/keys/it's"}).gitContext(t.Context(), testPrivateRepo)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := `GIT_SSH_COMMAND=ssh -i '/keys/it'\''s' -o IdentitiesOnly=yes`
+		if env := gitCommandEnv(ctx); !slices.Contains(env, want) {
+			t.Errorf("env = %v, want %q", env, want)
+		}
+	})
+
+	t.Run("token stays out of git arguments and logs", func(t *testing.T) {
+		const secret = "s3cr3t-t0ken"
+		t.Setenv("DEMOD_TEST_TOKEN", secret)
+		ctx, err := (&Auth{TokenEnv: "DEMOD_TEST_TOKEN"}).gitContext(t.Context(), testPrivateRepo)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var logs bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		mirror := setupMirror(t, setupBareRepo(t))
+		if _, err := gitLsRemote(ctx, logger, mirror); err != nil {
+			t.Fatalf("gitLsRemote: %v", err)
+		}
+		if strings.Contains(logs.String(), secret) {
+			t.Errorf("logs contain the token:\n%s", logs.String())
+		}
+	})
+}
+
+func TestGitCommandEnv(t *testing.T) {
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "demod.test")
+	t.Setenv("GIT_CONFIG_VALUE_0", "ambient")
+	ctx := withGitConfig(t.Context(), "demod.a", "1")
+	ctx = withGitConfig(ctx, "demod.b", "2")
+
+	want := []string{
+		"GIT_CONFIG_KEY_1=demod.a", "GIT_CONFIG_VALUE_1=1",
+		"GIT_CONFIG_KEY_2=demod.b", "GIT_CONFIG_VALUE_2=2",
+		"GIT_CONFIG_COUNT=3",
+	}
+	if got := gitCommandEnv(ctx); !slices.Equal(got, want) {
+		t.Errorf("gitCommandEnv = %v, want %v", got, want)
+	}
+
+	out, err := gitOutput(ctx, slog.Default(), "", "config", "--get-regexp", `^demod\.`)
+	if err != nil {
+		t.Fatalf("git config: %v", err)
+	}
+	if got := strings.Fields(out); !slices.Equal(got, []string{"demod.test", "ambient", "demod.a", "1", "demod.b", "2"}) {
+		t.Errorf("git config = %q, want the ambient and the added values", out)
+	}
+}
+
+// testPrivateRepo is the repo the credentials of TestAuthGitContext are for.
+const testPrivateRepo = "https://example.com/org/private.git"
+
+// credentialFill asks git for the credentials of https://host in ctx.
+func credentialFill(ctx context.Context, host string) (string, error) {
+	cmd := exec.Command("git", "credential", "fill")
+	cmd.Env = append(os.Environ(), gitCommandEnv(ctx)...)
+	cmd.Stdin = strings.NewReader("protocol=https\nhost=" + host + "\n\n")
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
@@ -0,0 +1,286 @@
+package demod
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mirrorLocks serializes access to each mirror within the process, keyed by mirror path.
+// Other processes sharing the cache are kept out by the lock file next to each mirror.
+var mirrorLocks sync.Map
+
+// mirrorLockPoll is how often a mirror locked by another process is checked for release.
+const mirrorLockPoll = 100 * time.Millisecond
+
+// DefaultCacheDir returns the default cache directory, demod under the user cache directory.
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("locating user cache dir: %w", err)
+	}
+	return filepath.Join(dir, "demod"), nil
+}
+
+// mirrorPath returns the path of the bare mirror of repo in the cache at dir.
+func mirrorPath(dir, repo string) string {
+	sum := sha256.Sum256([]byte(repo))
+	return filepath.Join(dir, "repos", hex.EncodeToString(sum[:8])+".git")
+}
+
+// openMirror returns the bare mirror of repo in the cache at dir, creating it if needed,
+// and locks it, both within the process and against other processes, until the returned
+// function is called. Stale worktrees left behind by earlier syncs are pruned.
+func openMirror(ctx context.Context, logger *slog.Logger, dir, repo string) (string, func(), error) {
+	mirror := mirrorPath(dir, repo)
+	mu, _ := mirrorLocks.LoadOrStore(mirror, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	release, err := lockMirror(ctx, logger, mirror)
+	if err != nil {
+		mu.(*sync.Mutex).Unlock()
+		return "", nil, err
+	}
+	unlock := func() {
+		release()
+		mu.(*sync.Mutex).Unlock()
+	}
+
+	if _, err := os.Stat(mirror); errors.Is(err, fs.ErrNotExist) {
+		logger.Debug("creating mirror", "path", mirror)
+		if err := initMirror(ctx, logger, mirror, repo); err != nil {
+			unlock()
+			return "", nil, err
+		}
+	} else if err != nil {
+		unlock()
+		return "", nil, err
+	} else if err := gitWorktreePrune(ctx, logger, mirror); err != nil {
+		unlock()
+		return "", nil, err
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(mirror, now, now)
+	return mirror, unlock, nil
+}
+
+// lockMirror takes the lock file of mirror, waiting while another process holds it
+// until ctx is done. It returns a function that releases the lock.
+func lockMirror(ctx context.Context, logger *slog.Logger, mirror string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(mirror), 0o755); err != nil {
+		return nil, fmt.Errorf("creating cache dir: %w", err)
+	}
+	f, err := os.OpenFile(mirror+".lock", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("locking mirror: %w", err)
+	}
+	for waiting := false; ; waiting = true {
+		err := tryLockFile(f)
+		if err == nil {
+			return func() { _ = f.Close() }, nil
+		}
+		if !errors.Is(err, errLocked) {
+			_ = f.Close()
+			return nil, fmt.Errorf("locking mirror: %w", err)
+		}
+		if !waiting {
+			logger.Info("waiting for another demod process to release the mirror", "path", mirror)
+		}
+		select {
+		case <-ctx.Done():
+			_ = f.Close()
+			return nil, context.Cause(ctx)
+		case <-time.After(mirrorLockPoll):
+		}
+	}
+}
+
+// initMirror creates the mirror of repo in a temporary directory and moves it into place,
+// so that an interrupted init never leaves a half-initialized mirror behind. Only the
+// temporary directory is removed on failure.
+func initMirror(ctx context.Context, logger *slog.Logger, mirror, repo string) error {
+	tmp, err := os.MkdirTemp(filepath.Dir(mirror), ".init-*")
+	if err != nil {
+		return fmt.Errorf("creating mirror: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+	if err := gitInitMirror(ctx, logger, tmp, repo); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, mirror); err != nil {
+		return fmt.Errorf("creating mirror: %w", err)
+	}
+	return nil
+}
+
+// CachedRepo describes a mirror in the cache.
+type CachedRepo struct {
+	Repo     string
+	Path     string
+	Size     int64
+	LastUsed time.Time
+}
+
+// ListCache returns the mirrors in the cache at dir, sorted by repo.
+func ListCache(dir string) ([]CachedRepo, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, "repos"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading cache: %w", err)
+	}
+
+	var repos []CachedRepo
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasSuffix(e.Name(), ".git") {
+			continue
+		}
+		path := filepath.Join(dir, "repos", e.Name())
+		info, err := e.Info()
+		if err != nil {
+			return nil, fmt.Errorf("reading cache: %w", err)
+		}
+		size, err := dirSize(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading cache: %w", err)
+		}
+		url, err := cachedRepo(path)
+		if err != nil {
+			url = "(unknown)"
+		}
+		repos = append(repos, CachedRepo{
+			Repo:     strings.TrimSpace(url),
+			Path:     path,
+			Size:     size,
+			LastUsed: info.ModTime(),
+		})
+	}
+	slices.SortFunc(repos, func(a, b CachedRepo) int { return strings.Compare(a.Repo, b.Repo) })
+	return repos, nil
+}
+
+// cachedRepo returns the configured repo of the mirror at path. Mirrors created before
+// demod.repo was recorded fall back to their origin.
+func cachedRepo(path string) (string, error) {
+	ctx, logger := context.Background(), slog.New(slog.DiscardHandler)
+	url, err := gitOutput(ctx, logger, path, "config", "--get", "demod.repo")
+	if err != nil {
+		url, err = gitOutput(ctx, logger, path, "config", "--get", "remote.origin.url")
+	}
+	return url, err
+}
+
+// PruneCache removes the mirrors in the cache at dir whose repo is not used by any module in cfg.
+// Each mirror is locked before it is removed, so a sync using it in another process finishes first.
+// It returns the removed mirrors.
+func PruneCache(ctx context.Context, logger *slog.Logger, dir string, cfg *Config) ([]CachedRepo, error) {
+	repos, err := ListCache(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []CachedRepo
+	for _, r := range repos {
+		used := slices.ContainsFunc(cfg.Modules, func(mod Module) bool {
+			return mirrorPath(dir, mod.Repo) == r.Path
+		})
+		if used {
+			continue
+		}
+		if err := removeMirror(ctx, logger, r.Path); err != nil {
+			return pruned, err
+		}
+		pruned = append(pruned, r)
+	}
+	return pruned, nil
+}
+
+// CleanCache removes every mirror in the cache at dir, each under its lock like PruneCache,
+// and then everything else in the cache but the lock files of the mirrors.
+func CleanCache(ctx context.Context, logger *slog.Logger, dir string) error {
+	repos, err := ListCache(dir)
+	if err != nil {
+		return err
+	}
+	for _, r := range repos {
+		if err := removeMirror(ctx, logger, r.Path); err != nil {
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("removing cache: %w", err)
+	}
+	for _, e := range entries {
+		if e.Name() == "repos" {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return fmt.Errorf("removing cache: %w", err)
+		}
+	}
+	entries, err = os.ReadDir(filepath.Join(dir, "repos"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("removing cache: %w", err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".lock") {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, "repos", e.Name())); err != nil {
+			return fmt.Errorf("removing cache: %w", err)
+		}
+	}
+	return nil
+}
+
+// removeMirror removes the mirror at path once no other sync uses it. Its lock file is kept:
+// a process waiting for the lock holds it open, and a new lock file would let a third process
+// use the mirror at the same time.
+func removeMirror(ctx context.Context, logger *slog.Logger, mirror string) error {
+	mu, _ := mirrorLocks.LoadOrStore(mirror, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	defer mu.(*sync.Mutex).Unlock()
+	release, err := lockMirror(ctx, logger, mirror)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := os.RemoveAll(mirror); err != nil {
+		return fmt.Errorf("removing %s: %w", mirror, err)
+	}
+	return nil
+}
+
+func dirSize(root string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(root, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,207 @@
+package demod
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSyncModule_Cache(t *testing.T) {
+	bare := setupBareRepo(t)
+	cacheDir := t.TempDir()
+	dest := filepath.Join(t.TempDir(), "dest")
+	mod := Module{
+		Name:     "test",
+		Repo:     bare,
+		Revision: "main",
+		Dest:     dest,
+		Paths:    []Path{{Src: "src/lib", As: "lib"}},
+	}
+	opts := SyncOptions{CacheDir: cacheDir}
+
+	if _, err := SyncModule(t.Context(), mod, nil, opts); err != nil {
+		t.Fatalf("SyncModule: %v", err)
+	}
+	if _, err := os.Stat(mirrorPath(cacheDir, bare)); err != nil {
+		t.Fatalf("expected mirror in cache: %v", err)
+	}
+
+	commit := pushCommit(t, bare, map[string]string{"src/lib/a.txt": "changed"})
+
+	entry, err := SyncModule(t.Context(), mod, nil, opts)
+	if err != nil {
+		t.Fatalf("SyncModule: %v", err)
+	}
+	if entry.Commit != commit {
+		t.Errorf("commit = %q, want %q", entry.Commit, commit)
+	}
+	assertFileContent(t, filepath.Join(dest, "lib", "a.txt"), "changed")
+
+	repos, err := ListCache(cacheDir)
+	if err != nil {
+		t.Fatalf("ListCache: %v", err)
+	}
+	if len(repos) != 1 {
+		t.Fatalf("len(repos) = %d, want 1", len(repos))
+	}
+	if repos[0].Repo != bare {
+		t.Errorf("repo = %q, want %q", repos[0].Repo, bare)
+	}
+	if repos[0].Size == 0 {
+		t.Error("expected non-zero mirror size")
+	}
+}
+
+func TestOpenMirror_Lock(t *testing.T) {
+	bare := setupBareRepo(t)
+	cacheDir := t.TempDir()
+	mirror := mirrorPath(cacheDir, bare)
+	if err := os.MkdirAll(filepath.Dir(mirror), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another demod process holds the lock of the mirror.
+	other, err := os.OpenFile(mirror+".lock", os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = other.Close() }()
+	if err := tryLockFile(other); err != nil {
+		t.Fatalf("tryLockFile: %v", err)
+	}
+	probe, err := os.Open(mirror + ".lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tryLockFile(probe); err == nil {
+		_ = probe.Close()
+		t.Skip("file locks are not supported on this platform")
+	}
+	_ = probe.Close()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 300*time.Millisecond)
+	defer cancel()
+	if _, _, err := openMirror(ctx, slog.Default(), cacheDir, bare); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want context.DeadlineExceeded while the mirror is locked", err)
+	}
+	if _, err := os.Stat(mirror); !os.IsNotExist(err) {
+		t.Errorf("expected no mirror to be created while locked, got err: %v", err)
+	}
+
+	_ = other.Close()
+	got, unlock, err := openMirror(t.Context(), slog.Default(), cacheDir, bare)
+	if err != nil {
+		t.Fatalf("openMirror: %v", err)
+	}
+	defer unlock()
+	if got != mirror {
+		t.Errorf("mirror = %q, want %q", got, mirror)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(mirror), ".init-*")); len(matches) != 0 {
+		t.Errorf("temporary init dirs left behind: %v", matches)
+	}
+}
+
+func TestListCache_RewrittenRepo(t *testing.T) {
+	bare := setupBareRepo(t)
+	cacheDir := t.TempDir()
+	mod := Module{
+		Name:     "test",
+		Repo:     "https://example.invalid/test.git",
+		URLs:     []string{bare},
+		Revision: "main",
+		Dest:     filepath.Join(t.TempDir(), "dest"),
+		Paths:    []Path{{Src: "src/lib"}},
+	}
+	if _, err := SyncModule(t.Context(), mod, nil, SyncOptions{CacheDir: cacheDir}); err != nil {
+		t.Fatalf("SyncModule: %v", err)
+	}
+
+	repos, err := ListCache(cacheDir)
+	if err != nil {
+		t.Fatalf("ListCache: %v", err)
+	}
+	if len(repos) != 1 || repos[0].Repo != mod.Repo {
+		t.Errorf("repos = %+v, want only %s", repos, mod.Repo)
+	}
+}
+
+func TestListCache_Empty(t *testing.T) {
+	repos, err := ListCache(filepath.Join(t.TempDir(), "missing"))
+	if err != nil {
+		t.Fatalf("ListCache: %v", err)
+	}
+	if len(repos) != 0 {
+		t.Errorf("len(repos) = %d, want 0", len(repos))
+	}
+}
+
+func TestPruneCache(t *testing.T) {
+	cacheDir := t.TempDir()
+	used := setupBareRepo(t)
+	unused := setupBareRepo(t)
+	for _, repo := range []string{used, unused} {
+		if err := gitInitMirror(t.Context(), slog.Default(), mirrorPath(cacheDir, repo), repo); err != nil {
+			t.Fatalf("gitInitMirror: %v", err)
+		}
+	}
+
+	cfg := &Config{Modules: []Module{{Name: "used", Repo: used}}}
+	pruned, err := PruneCache(t.Context(), slog.Default(), cacheDir, cfg)
+	if err != nil {
+		t.Fatalf("PruneCache: %v", err)
+	}
+	if len(pruned) != 1 || pruned[0].Repo != unused {
+		t.Errorf("pruned = %+v, want only %s", pruned, unused)
+	}
+	if _, err := os.Stat(mirrorPath(cacheDir, used)); err != nil {
+		t.Errorf("expected used mirror to be kept: %v", err)
+	}
+	if _, err := os.Stat(mirrorPath(cacheDir, unused)); !os.IsNotExist(err) {
+		t.Errorf("expected unused mirror to be removed, got err: %v", err)
+	}
+}
+
+func TestPruneCache_Locked(t *testing.T) {
+	cacheDir := t.TempDir()
+	unused := setupBareRepo(t)
+	mirror := mirrorPath(cacheDir, unused)
+	if err := gitInitMirror(t.Context(), slog.Default(), mirror, unused); err != nil {
+		t.Fatalf("gitInitMirror: %v", err)
+	}
+	// Another process syncing from the mirror holds its lock.
+	release, err := lockMirror(t.Context(), slog.Default(), mirror)
+	if err != nil {
+		t.Fatalf("lockMirror: %v", err)
+	}
+	defer release()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 3*mirrorLockPoll)
+	defer cancel()
+	if _, err := PruneCache(ctx, slog.Default(), cacheDir, &Config{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want the prune to wait for the lock", err)
+	}
+	if _, err := os.Stat(mirror); err != nil {
+		t.Errorf("expected the locked mirror to be kept: %v", err)
+	}
+}
+
+func TestCleanCache(t *testing.T) {
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	writeFiles(t, cacheDir, map[string]string{"repos/x.git/HEAD": "ref", "repos/x.git.lock": "", "repos/.init-1/HEAD": "ref"})
+
+	if err := CleanCache(t.Context(), slog.Default(), cacheDir); err != nil {
+		t.Fatalf("CleanCache: %v", err)
+	}
+	entries, err := os.ReadDir(filepath.Join(cacheDir, "repos"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "x.git.lock" {
+		t.Errorf("cache entries = %v, want only the lock file", entries)
+	}
+}
@@ -0,0 +1,221 @@
+package demod
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// ModuleChangelog lists what changed upstream in a module between two commits.
+type ModuleChangelog struct {
+	Module string `json:"module"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	// Commits are the commits between From and To that touched a module path, newest first.
+	Commits []ChangelogCommit `json:"commits"`
+	// Files are the vendored files a sync from From to To adds, modifies or removes.
+	Files []FileChange `json:"files"`
+}
+
+// ChangelogCommit is an upstream commit in a ModuleChangelog.
+type ChangelogCommit struct {
+	Commit  string    `json:"commit"`
+	Author  string    `json:"author"`
+	Date    time.Time `json:"date"`
+	Subject string    `json:"subject"`
+}
+
+// FileChange is a change to a vendored file. Path is slash-separated and relative to the module dest.
+type FileChange struct {
+	// Status is "added", "modified" or "removed".
+	Status string `json:"status"`
+	Path   string `json:"path"`
+}
+
+// Changelog returns the upstream changes to the paths of the named module between the
+// revisions from and to. An empty from defaults to the locked commit, and an empty to
+// to the latest upstream commit as reported by Outdated.
+func Changelog(ctx context.Context, cfg *Config, name, from, to string, opts SyncOptions) (*ModuleChangelog, error) {
+	i := slices.IndexFunc(cfg.Modules, func(mod Module) bool { return mod.Name == name })
+	if i < 0 {
+		return nil, fmt.Errorf("unknown module: %s", name)
+	}
+	mod := cfg.Modules[i]
+	ctx, cancel := moduleContext(opts.gitContext(ctx), mod)
+	defer cancel()
+	ctx, err := opts.authContext(ctx, mod)
+	if err != nil {
+		return nil, fmt.Errorf("[%s] %w", mod.Name, err)
+	}
+
+	if from == "" {
+		lock, err := loadLockOrEmpty(opts.LockFile)
+		if err != nil {
+			return nil, err
+		}
+		locked := lock.Find(mod.Name)
+		if locked == nil || locked.Repo != mod.Repo {
+			return nil, fmt.Errorf("[%s] module is not locked; pass the revision to compare from", mod.Name)
+		}
+		from = locked.Commit
+	}
+
+	tmpdir, err := os.MkdirTemp("", "demod-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating temp dir: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpdir) }()
+
+	changelog, err := moduleChangelog(ctx, WithModule(opts.logger(), mod.Name), mod, from, to, opts.cacheDir(tmpdir))
+	if err != nil {
+		return nil, fmt.Errorf("[%s] %w", mod.Name, err)
+	}
+	return changelog, nil
+}
+
+func moduleChangelog(ctx context.Context, logger *slog.Logger, mod Module, from, to, cacheDir string) (*ModuleChangelog, error) {
+	mirror, unlock, err := openMirror(ctx, logger, cacheDir, mod.Repo)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var changelog *ModuleChangelog
+	err = withRemotes(ctx, logger, mirror, mod.remotes(), func() (err error) {
+		changelog, err = mirrorChangelog(ctx, logger, mirror, mod, from, to)
+		return err
+	})
+	return changelog, err
+}
+
+// mirrorChangelog is moduleChangelog fetching from the current origin of mirror.
+func mirrorChangelog(ctx context.Context, logger *slog.Logger, mirror string, mod Module, from, to string) (*ModuleChangelog, error) {
+	if to == "" {
+		refs, err := gitLsRemote(ctx, logger, mirror)
+		if err != nil {
+			return nil, err
+		}
+		ref, _, err := latestRef(refs, mod.Revision)
+		if err != nil {
+			return nil, err
+		}
+		to = mod.Revision
+		if ref != "" {
+			to = refs[ref]
+		}
+	}
+
+	// Listing the commits in between needs the history of both commits.
+	logger.Info("fetching history")
+	if err := gitFetchAll(ctx, logger, mirror); err != nil {
+		return nil, err
+	}
+	changelog := &ModuleChangelog{Module: mod.Name}
+	var err error
+	if changelog.From, _, err = fetchRevision(ctx, logger, mirror, from); err != nil {
+		return nil, err
+	}
+	if changelog.To, _, err = fetchRevision(ctx, logger, mirror, to); err != nil {
+		return nil, err
+	}
+
+	srcs := make([]string, len(mod.Paths))
+	for i, p := range mod.Paths {
+		srcs[i] = p.Src
+	}
+	rng := changelog.From + ".." + changelog.To
+
+	out, err := gitOutput(ctx, logger, mirror, append([]string{"log", "--format=%H%x00%an%x00%aI%x00%s", rng, "--"}, srcs...)...)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "\x00", 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("parsing git log: unexpected line %q", line)
+		}
+		date, err := time.Parse(time.RFC3339, fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("parsing git log: %w", err)
+		}
+		changelog.Commits = append(changelog.Commits, ChangelogCommit{Commit: fields[0], Author: fields[1], Date: date, Subject: fields[3]})
+	}
+
+	out, err = gitOutput(ctx, logger, mirror, append([]string{"diff", "--name-status", "--no-renames", "-z", changelog.From, changelog.To, "--"}, srcs...)...)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		changes, err := vendoredChanges(mod.Paths, diffStatus(fields[i]), fields[i+1])
+		if err != nil {
+			return nil, err
+		}
+		changelog.Files = append(changelog.Files, changes...)
+	}
+	slices.SortFunc(changelog.Files, func(a, b FileChange) int { return strings.Compare(a.Path, b.Path) })
+	changelog.Files = slices.Compact(changelog.Files)
+	return changelog, nil
+}
+
+func diffStatus(status string) string {
+	switch status {
+	case "A":
+		return "added"
+	case "D":
+		return "removed"
+	default:
+		return "modified"
+	}
+}
+
+// vendoredChanges maps a change to the upstream file at the slash-separated path file to the
+// vendored files it changes: one for every module path that includes the file and does not exclude it.
+func vendoredChanges(paths []Path, status, file string) ([]FileChange, error) {
+	var changes []FileChange
+	for _, p := range paths {
+		src := path.Clean(p.Src)
+		rel, ok := strings.CutPrefix(file, src+"/")
+		if src == "." {
+			rel, ok = file, true
+		}
+		if !ok {
+			continue
+		}
+		excluded, err := excludedPath(p, rel)
+		if err != nil {
+			return nil, err
+		}
+		if !excluded {
+			changes = append(changes, FileChange{Status: status, Path: path.Join(path.Clean(p.dest()), rel)})
+		}
+	}
+	return changes, nil
+}
+
+// excludedPath reports whether the slash-separated path rel, relative to p.Src, or one of its
+// parent directories matches an exclude pattern of p.
+func excludedPath(p Path, rel string) (bool, error) {
+	for prefix := rel; prefix != "."; prefix = path.Dir(prefix) {
+		for _, pattern := range p.Exclude {
+			matched, err := doublestar.Match(pattern, prefix)
+			if err != nil {
+				return false, fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+			}
+			if matched {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
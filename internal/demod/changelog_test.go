@@ -0,0 +1,112 @@
+package demod
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestChangelog(t *testing.T) {
+	bare := setupBareRepo(t)
+	dir := t.TempDir()
+	lockFile := filepath.Join(dir, LockFileName)
+	cfg := &Config{
+		Version: 1,
+		Modules: []Module{{
+			Name:     "test",
+			Repo:     bare,
+			Revision: "main",
+			Dest:     filepath.Join(dir, "dest"),
+			Paths:    []Path{{Src: "src/lib", As: "lib", Exclude: []string{"*.md"}}},
+		}},
+	}
+	opts := SyncOptions{LockFile: lockFile, CacheDir: t.TempDir()}
+
+	if err := SyncAll(t.Context(), cfg, opts); err != nil {
+		t.Fatalf("SyncAll: %v", err)
+	}
+	first := gitTestOutput(t, bare, "rev-parse", "main")
+	libChange := pushCommit(t, bare, map[string]string{"src/lib/a.txt": "aaa2", "src/lib/c.txt": "ccc", "src/lib/notes.md": "notes"})
+	pushCommit(t, bare, map[string]string{"docs/readme.txt": "changed"})
+	gitTestOutput(t, bare, "tag", "v1", "main")
+
+	t.Run("from locked commit to latest", func(t *testing.T) {
+		changelog, err := Changelog(t.Context(), cfg, "test", "", "", opts)
+		if err != nil {
+			t.Fatalf("Changelog: %v", err)
+		}
+		if changelog.From != first {
+			t.Errorf("from = %q, want %q", changelog.From, first)
+		}
+		if len(changelog.Commits) != 1 || changelog.Commits[0].Commit != libChange {
+			t.Errorf("commits = %+v, want only %s", changelog.Commits, libChange)
+		}
+		want := []FileChange{{Status: "modified", Path: "lib/a.txt"}, {Status: "added", Path: "lib/c.txt"}}
+		if !slices.Equal(changelog.Files, want) {
+			t.Errorf("files = %+v, want %+v", changelog.Files, want)
+		}
+	})
+
+	t.Run("explicit revisions", func(t *testing.T) {
+		changelog, err := Changelog(t.Context(), cfg, "test", "v1", first, opts)
+		if err != nil {
+			t.Fatalf("Changelog: %v", err)
+		}
+		if len(changelog.Commits) != 0 {
+			t.Errorf("commits = %+v, want none", changelog.Commits)
+		}
+		want := []FileChange{{Status: "modified", Path: "lib/a.txt"}, {Status: "removed", Path: "lib/c.txt"}}
+		if !slices.Equal(changelog.Files, want) {
+			t.Errorf("files = %+v, want %+v", changelog.Files, want)
+		}
+	})
+
+	t.Run("unknown module", func(t *testing.T) {
+		if _, err := Changelog(t.Context(), cfg, "nonexistent", "", "", opts); err == nil {
+			t.Fatal("expected error for unknown module")
+		}
+	})
+
+	t.Run("unlocked module without from", func(t *testing.T) {
+		if _, err := Changelog(t.Context(), cfg, "test", "", "", SyncOptions{CacheDir: opts.CacheDir}); err == nil {
+			t.Fatal("expected error for unlocked module")
+		}
+	})
+}
+
+func TestVendoredChanges(t *testing.T) {
+	paths := []Path{
+		{Src: "src/lib", As: "lib", Exclude: []string{"internal/**"}},
+		{Src: "src/lib/api"},
+		{Src: "."},
+	}
+
+	got, err := vendoredChanges(paths, "added", "src/lib/api/x.proto")
+	if err != nil {
+		t.Fatalf("vendoredChanges: %v", err)
+	}
+	want := []FileChange{
+		{Status: "added", Path: "lib/api/x.proto"},
+		{Status: "added", Path: "src/lib/api/x.proto"},
+		{Status: "added", Path: "src/lib/api/x.proto"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("changes = %+v, want %+v", got, want)
+	}
+
+	got, err = vendoredChanges(paths[:1], "modified", "src/lib/internal/deep/y.go")
+	if err != nil {
+		t.Fatalf("vendoredChanges: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("changes = %+v, want none for excluded file", got)
+	}
+
+	got, err = vendoredChanges(paths[:1], "modified", "src/library/z.go")
+	if err != nil {
+		t.Fatalf("vendoredChanges: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("changes = %+v, want none for file outside the module paths", got)
+	}
+}
@@ -0,0 +1,213 @@
+package demod
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// Drift lists the files in a module dest that differ from what a sync would write.
+// Paths are slash-separated and relative to the module dest.
+type Drift struct {
+	Module string
+	// Added are files a sync would add.
+	Added []string
+	// Removed are files a sync would remove.
+	Removed []string
+	// Modified are files whose content a sync would change.
+	Modified []string
+}
+
+func (d Drift) empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// Check computes what a sync would write for every module and compares it with the current
+// content of each module dest, without modifying it. Locked modules are checked at their
+// locked commit. It returns the drift of every module that is out of date.
+// With KeepGoing, the drift of the modules that could be checked is returned along with the ModuleErrors.
+func Check(ctx context.Context, cfg *Config, opts SyncOptions) ([]Drift, error) {
+	lock, err := loadLockOrEmpty(opts.LockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	ws, err := newWorkspace(opts, cfg.Modules)
+	if err != nil {
+		return nil, err
+	}
+	defer ws.close()
+
+	drifts := make([]Drift, len(cfg.Modules))
+	err = forEachModule(ctx, cfg, opts, func(ctx context.Context, i int, mod Module) error {
+		drift, err := checkModule(ctx, mod, lock.pinned(mod), opts, ws)
+		if err != nil {
+			return err
+		}
+		drifts[i] = *drift
+		return nil
+	})
+	var failed ModuleErrors
+	if err != nil && !errors.As(err, &failed) {
+		return nil, err
+	}
+
+	var outdated []Drift
+	for _, d := range drifts {
+		if !d.empty() {
+			outdated = append(outdated, d)
+		}
+	}
+	if failed != nil {
+		return outdated, failed
+	}
+	return outdated, nil
+}
+
+func checkModule(ctx context.Context, mod Module, pinned *LockedModule, opts SyncOptions, ws *workspace) (*Drift, error) {
+	logger := WithModule(opts.logger(), mod.Name)
+	ctx, cancel := moduleContext(opts.gitContext(ctx), mod)
+	defer cancel()
+	ctx, err := opts.authContext(ctx, mod)
+	if err != nil {
+		return nil, stageError(mod, StagePrepare, err)
+	}
+
+	workdir, _, _, err := checkoutModule(ctx, logger, mod, pinned, ws)
+	if err != nil {
+		return nil, err
+	}
+
+	expected, err := ws.tempDir("expected-*")
+	if err != nil {
+		return nil, stageError(mod, StagePrepare, err)
+	}
+	defer func() { _ = os.RemoveAll(expected) }()
+	if err := copyPaths(workdir, expected, mod.Paths, nil); err != nil {
+		return nil, stageError(mod, StageCopy, err)
+	}
+
+	managed, err := readManifestOrEmpty(mod.Dest)
+	if err != nil {
+		return nil, stageError(mod, StagePrepare, err)
+	}
+
+	logger.Info("comparing", "dest", mod.Dest)
+	drift, err := diffTrees(expected, mod.Dest, managed)
+	if err != nil {
+		return nil, stageError(mod, StageCopy, fmt.Errorf("comparing: %w", err))
+	}
+	drift.Module = mod.Name
+	return drift, nil
+}
+
+// diffTrees compares the files and symlinks under expected and actual byte-for-byte.
+// Files in actual that are neither expected nor in managed are not owned by demod and are ignored.
+// A missing actual directory is treated as empty.
+func diffTrees(expected, actual string, managed Manifest) (*Drift, error) {
+	want, err := listFiles(expected)
+	if err != nil {
+		return nil, err
+	}
+	got, err := listFiles(actual)
+	if err != nil {
+		return nil, err
+	}
+
+	var drift Drift
+	for _, rel := range want {
+		if _, ok := slices.BinarySearch(got, rel); !ok {
+			drift.Added = append(drift.Added, rel)
+			continue
+		}
+		same, err := sameEntry(filepath.Join(expected, rel), filepath.Join(actual, rel))
+		if err != nil {
+			return nil, err
+		}
+		if !same {
+			drift.Modified = append(drift.Modified, rel)
+		}
+	}
+	for _, rel := range got {
+		if _, ok := slices.BinarySearch(want, rel); ok {
+			continue
+		}
+		if _, ok := managed[rel]; ok {
+			drift.Removed = append(drift.Removed, rel)
+		}
+	}
+	return &drift, nil
+}
+
+// listFiles returns the sorted slash-separated paths of all files and symlinks under root,
+// except the manifest.
+func listFiles(root string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if fpath == root && os.IsNotExist(err) {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, fpath)
+		if err != nil {
+			return err
+		}
+		if rel == ManifestFileName {
+			return nil
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	slices.Sort(files)
+	return files, err
+}
+
+// sameEntry reports whether a and b are both symlinks with the same target,
+// or both regular files with the same permission bits and content.
+func sameEntry(a, b string) (bool, error) {
+	fa, err := os.Lstat(a)
+	if err != nil {
+		return false, err
+	}
+	fb, err := os.Lstat(b)
+	if err != nil {
+		return false, err
+	}
+	if fa.Mode().Type() != fb.Mode().Type() {
+		return false, nil
+	}
+	if fa.Mode()&fs.ModeSymlink != 0 {
+		la, err := os.Readlink(a)
+		if err != nil {
+			return false, err
+		}
+		lb, err := os.Readlink(b)
+		if err != nil {
+			return false, err
+		}
+		return la == lb, nil
+	}
+	if fa.Mode().Perm() != fb.Mode().Perm() || fa.Size() != fb.Size() {
+		return false, nil
+	}
+
+	da, err := os.ReadFile(a)
+	if err != nil {
+		return false, err
+	}
+	db, err := os.ReadFile(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(da, db), nil
+}
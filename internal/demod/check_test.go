@@ -0,0 +1,113 @@
+package demod
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDiffTrees(t *testing.T) {
+	expected := t.TempDir()
+	actual := t.TempDir()
+
+	writeFiles(t, expected, map[string]string{
+		"same.txt":     "same",
+		"changed.txt":  "new",
+		"sub/new.txt":  "new",
+		"sub/keep.txt": "keep",
+	})
+	writeFiles(t, actual, map[string]string{
+		"same.txt":     "same",
+		"changed.txt":  "old",
+		"sub/keep.txt": "keep",
+		"stale.txt":    "stale",
+		"local.txt":    "not managed by demod",
+	})
+	managed := Manifest{"same.txt": "", "changed.txt": "", "sub/keep.txt": "", "stale.txt": ""}
+
+	drift, err := diffTrees(expected, actual, managed)
+	if err != nil {
+		t.Fatalf("diffTrees: %v", err)
+	}
+	if !slices.Equal(drift.Added, []string{"sub/new.txt"}) {
+		t.Errorf("added = %v, want [sub/new.txt]", drift.Added)
+	}
+	if !slices.Equal(drift.Removed, []string{"stale.txt"}) {
+		t.Errorf("removed = %v, want [stale.txt]", drift.Removed)
+	}
+	if !slices.Equal(drift.Modified, []string{"changed.txt"}) {
+		t.Errorf("modified = %v, want [changed.txt]", drift.Modified)
+	}
+}
+
+func TestDiffTrees_MissingActual(t *testing.T) {
+	expected := t.TempDir()
+	writeFiles(t, expected, map[string]string{"a.txt": "aaa"})
+
+	drift, err := diffTrees(expected, filepath.Join(t.TempDir(), "missing"), nil)
+	if err != nil {
+		t.Fatalf("diffTrees: %v", err)
+	}
+	if !slices.Equal(drift.Added, []string{"a.txt"}) {
+		t.Errorf("added = %v, want [a.txt]", drift.Added)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	bare := setupBareRepo(t)
+	dir := t.TempDir()
+	dest := filepath.Join(dir, "dest")
+	cfg := &Config{
+		Version: 1,
+		Modules: []Module{{
+			Name:     "test",
+			Repo:     bare,
+			Revision: "main",
+			Dest:     dest,
+			Paths:    []Path{{Src: "src/lib", As: "lib"}},
+		}},
+	}
+	opts := SyncOptions{LockFile: filepath.Join(dir, LockFileName)}
+
+	if err := SyncAll(t.Context(), cfg, opts); err != nil {
+		t.Fatalf("SyncAll: %v", err)
+	}
+
+	drifts, err := Check(t.Context(), cfg, opts)
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if len(drifts) != 0 {
+		t.Fatalf("drifts = %+v, want none", drifts)
+	}
+
+	if err := os.WriteFile(filepath.Join(dest, "lib", "a.txt"), []byte("edited"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	drifts, err = Check(t.Context(), cfg, opts)
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if len(drifts) != 1 {
+		t.Fatalf("len(drifts) = %d, want 1", len(drifts))
+	}
+	if !slices.Equal(drifts[0].Modified, []string{"lib/a.txt"}) {
+		t.Errorf("modified = %v, want [lib/a.txt]", drifts[0].Modified)
+	}
+	assertFileContent(t, filepath.Join(dest, "lib", "a.txt"), "edited")
+}
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for path, content := range files {
+		abs := filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(abs, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
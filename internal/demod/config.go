@@ -5,28 +5,90 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
 
 type Config struct {
-	Version  int      `toml:"version"`
-	DestRoot string   `toml:"dest_root"`
-	Modules  []Module `toml:"modules"`
+	Version     int         `toml:"version"`
+	DestRoot    string      `toml:"dest_root"`
+	CacheDir    string      `toml:"cache_dir"`
+	Jobs        int         `toml:"jobs"`
+	JobsPerHost int         `toml:"jobs_per_host"`
+	Retry       RetryPolicy `toml:"retry"`
+	// Timeout and GitTimeout are the defaults for the module settings of the same name.
+	Timeout    time.Duration `toml:"timeout"`
+	GitTimeout time.Duration `toml:"git_timeout"`
+	Rewrites   []Rewrite     `toml:"rewrite"`
+	Modules    []Module      `toml:"modules"`
 }
 
+// Symlink policies for Path.Symlinks.
+const (
+	// SymlinksPreserve copies symlinks as symlinks. They must point inside the module dest, with
+	// any ".." only at the start of the target.
+	SymlinksPreserve = "preserve"
+	// SymlinksFollow copies the file or directory a symlink points to. It must be under the Src of a
+	// module path, or be a file directly in the repository root or in a directory containing such a Src.
+	SymlinksFollow = "follow"
+	// SymlinksSkip leaves symlinks out.
+	SymlinksSkip = "skip"
+)
+
 type Path struct {
-	Src     string   `toml:"src"`
-	As      string   `toml:"as"`
-	Exclude []string `toml:"exclude"`
+	Src      string   `toml:"src"`
+	As       string   `toml:"as"`
+	Exclude  []string `toml:"exclude"`
+	Symlinks string   `toml:"symlinks"`
+	// Tree pins the expected content of Src: either the git tree ID of Src,
+	// or the "sha256:" content digest of the vendored files recorded in the lock file.
+	Tree string `toml:"tree"`
+}
+
+// dest returns the destination path of p relative to the module dest.
+func (p Path) dest() string {
+	if p.As != "" {
+		return p.As
+	}
+	return p.Src
+}
+
+// symlinks returns the symlink policy of p, which defaults to SymlinksPreserve.
+func (p Path) symlinks() string {
+	if p.Symlinks == "" {
+		return SymlinksPreserve
+	}
+	return p.Symlinks
 }
 
 type Module struct {
-	Name     string `toml:"name"`
-	Repo     string `toml:"repo"`
-	Revision string `toml:"revision"`
-	Dest     string `toml:"dest"`
-	Paths    []Path `toml:"paths"`
+	Name     string           `toml:"name"`
+	Repo     string           `toml:"repo"`
+	Revision string           `toml:"revision"`
+	Dest     string           `toml:"dest"`
+	Paths    []Path           `toml:"paths"`
+	Verify   *SignaturePolicy `toml:"verify"`
+	// Timeout limits the whole sync of the module, including waiting for retries. Zero means no limit.
+	Timeout time.Duration `toml:"timeout"`
+	// GitTimeout limits every single git command run for the module. Zero means no limit.
+	GitTimeout time.Duration `toml:"git_timeout"`
+	// Mirrors are fetched from in order when fetching from Repo fails.
+	Mirrors []string `toml:"mirrors"`
+	// Auth replaces the ambient git credentials for fetching the module.
+	Auth *Auth `toml:"auth"`
+	// URLs are Repo and Mirrors with the rewrite rules of the config applied. Set by Load.
+	// Repo still identifies the module in the lock file and the cache.
+	URLs []string `toml:"-"`
+}
+
+// SignaturePolicy requires the commit a module is synced at, or the tag it was resolved from,
+// to be signed by one of the configured signers.
+type SignaturePolicy struct {
+	// AllowedSigners is an SSH allowed signers file (see ssh-keygen(1)).
+	AllowedSigners string `toml:"allowed_signers"`
+	// Keyring is a file of trusted GPG public keys, binary or ASCII-armored.
+	Keyring string `toml:"keyring"`
 }
 
 func Load(path string) (*Config, error) {
@@ -47,6 +109,19 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("unsupported config version: %d (expected 1)", cfg.Version)
 	}
 
+	if cfg.Jobs < 0 {
+		return nil, fmt.Errorf("jobs must not be negative: %d", cfg.Jobs)
+	}
+	if cfg.JobsPerHost < 0 {
+		return nil, fmt.Errorf("jobs_per_host must not be negative: %d", cfg.JobsPerHost)
+	}
+	if r := cfg.Retry; r.Attempts < 0 || r.InitialDelay < 0 || r.MaxDelay < 0 {
+		return nil, fmt.Errorf("retry: attempts and delays must not be negative")
+	}
+	if cfg.Timeout < 0 || cfg.GitTimeout < 0 {
+		return nil, fmt.Errorf("timeout and git_timeout must not be negative")
+	}
+
 	for i, mod := range cfg.Modules {
 		if mod.Name == "" {
 			return nil, fmt.Errorf("modules[%d]: name is required", i)
@@ -57,23 +132,64 @@ func Load(path string) (*Config, error) {
 		if mod.Revision == "" {
 			return nil, fmt.Errorf("modules[%d] (%s): revision is required", i, mod.Name)
 		}
+		if isConstraint(mod.Revision) {
+			if _, err := parseConstraint(mod.Revision); err != nil {
+				return nil, fmt.Errorf("modules[%d] (%s): %w", i, mod.Name, err)
+			}
+		}
 		if mod.Dest == "" {
 			return nil, fmt.Errorf("modules[%d] (%s): dest is required", i, mod.Name)
 		}
+		if mod.Timeout < 0 || mod.GitTimeout < 0 {
+			return nil, fmt.Errorf("modules[%d] (%s): timeout and git_timeout must not be negative", i, mod.Name)
+		}
 		if len(mod.Paths) == 0 {
 			return nil, fmt.Errorf("modules[%d] (%s): paths is required", i, mod.Name)
 		}
+		if v := mod.Verify; v != nil {
+			if v.AllowedSigners == "" && v.Keyring == "" {
+				return nil, fmt.Errorf("modules[%d] (%s): verify requires allowed_signers or keyring", i, mod.Name)
+			}
+			// git runs in the mirror, so the key files must not depend on the working directory.
+			for _, file := range []*string{&v.AllowedSigners, &v.Keyring} {
+				if *file == "" {
+					continue
+				}
+				if *file, err = filepath.Abs(*file); err != nil {
+					return nil, fmt.Errorf("modules[%d] (%s): %w", i, mod.Name, err)
+				}
+			}
+		}
+		if a := mod.Auth; a != nil {
+			if err := a.validate(mod.Repo); err != nil {
+				return nil, fmt.Errorf("modules[%d] (%s): %w", i, mod.Name, err)
+			}
+			if a.SSHKey != "" {
+				if a.SSHKey, err = filepath.Abs(a.SSHKey); err != nil {
+					return nil, fmt.Errorf("modules[%d] (%s): %w", i, mod.Name, err)
+				}
+			}
+		}
 		seen := make(map[string]struct{})
 		for j, p := range mod.Paths {
 			if p.Src == "" {
 				return nil, fmt.Errorf("modules[%d] (%s): paths[%d].src is required", i, mod.Name, j)
 			}
 
-			destPath := p.As
-			if destPath == "" {
-				destPath = p.Src
+			switch p.Symlinks {
+			case "", SymlinksPreserve, SymlinksFollow, SymlinksSkip:
+			default:
+				return nil, fmt.Errorf("modules[%d] (%s): paths[%d] has invalid symlinks policy %q (expected %q, %q or %q)", i, mod.Name, j, p.Symlinks, SymlinksPreserve, SymlinksFollow, SymlinksSkip)
+			}
+
+			if p.Tree != "" {
+				id := strings.TrimPrefix(p.Tree, "sha256:")
+				if !isCommitID(id) || isContentDigest(p.Tree) && len(id) != 64 {
+					return nil, fmt.Errorf("modules[%d] (%s): paths[%d] has invalid tree %q (expected a git tree ID or sha256:<hex>)", i, mod.Name, j, p.Tree)
+				}
 			}
 
+			destPath := p.dest()
 			cleaned := filepath.Clean(destPath)
 			if cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
 				return nil, fmt.Errorf("modules[%d] (%s): paths[%d] has invalid dest path %q: path traversal is not allowed", i, mod.Name, j, destPath)
@@ -86,6 +202,25 @@ func Load(path string) (*Config, error) {
 		}
 	}
 
+	for i, r := range cfg.Rewrites {
+		if r.From == "" || r.To == "" {
+			return nil, fmt.Errorf("rewrite[%d]: from and to are required", i)
+		}
+	}
+
+	for i := range cfg.Modules {
+		mod := &cfg.Modules[i]
+		for _, url := range append([]string{mod.Repo}, mod.Mirrors...) {
+			mod.URLs = append(mod.URLs, rewriteURL(cfg.Rewrites, url))
+		}
+		if mod.Timeout == 0 {
+			mod.Timeout = cfg.Timeout
+		}
+		if mod.GitTimeout == 0 {
+			mod.GitTimeout = cfg.GitTimeout
+		}
+	}
+
 	if cfg.DestRoot != "" {
 		for i := range cfg.Modules {
 			cfg.Modules[i].Dest = filepath.Join(cfg.DestRoot, cfg.Modules[i].Dest)
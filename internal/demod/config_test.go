@@ -3,7 +3,10 @@ package demod
 import (
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoad(t *testing.T) {
@@ -178,6 +181,24 @@ paths = [{ src = "src" }]
 		}
 	})
 
+	t.Run("invalid version constraint", func(t *testing.T) {
+		content := `
+version = 1
+
+[[modules]]
+name = "foo"
+repo = "https://github.com/example/foo"
+revision = "^1.x"
+dest = "vendor/foo"
+paths = [{ src = "src" }]
+`
+		path := writeTempConfig(t, content)
+		_, err := Load(path)
+		if err == nil {
+			t.Fatal("expected error for invalid version constraint")
+		}
+	})
+
 	t.Run("missing dest", func(t *testing.T) {
 		content := `
 version = 1
@@ -338,6 +359,310 @@ paths = [
 		}
 	})
 
+	t.Run("symlinks policy is parsed in path", func(t *testing.T) {
+		content := `
+version = 1
+
+[[modules]]
+name = "foo"
+repo = "https://github.com/example/foo"
+revision = "main"
+dest = "vendor/foo"
+paths = [
+  { src = "src", symlinks = "follow" },
+]
+`
+		path := writeTempConfig(t, content)
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := cfg.Modules[0].Paths[0].Symlinks; got != SymlinksFollow {
+			t.Errorf("symlinks = %q, want %q", got, SymlinksFollow)
+		}
+	})
+
+	t.Run("invalid symlinks policy", func(t *testing.T) {
+		content := `
+version = 1
+
+[[modules]]
+name = "foo"
+repo = "https://github.com/example/foo"
+revision = "main"
+dest = "vendor/foo"
+paths = [
+  { src = "src", symlinks = "copy" },
+]
+`
+		path := writeTempConfig(t, content)
+		_, err := Load(path)
+		if err == nil {
+			t.Fatal("expected error for invalid symlinks policy")
+		}
+	})
+
+	t.Run("verify block is parsed with absolute key paths", func(t *testing.T) {
+		content := `
+version = 1
+
+[[modules]]
+name = "foo"
+repo = "https://github.com/example/foo"
+revision = "v1.0.0"
+dest = "vendor/foo"
+paths = [{ src = "src" }]
+
+[modules.verify]
+allowed_signers = "keys/allowed_signers"
+`
+		path := writeTempConfig(t, content)
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		v := cfg.Modules[0].Verify
+		if v == nil {
+			t.Fatal("expected verify block")
+		}
+		if !filepath.IsAbs(v.AllowedSigners) || !strings.HasSuffix(v.AllowedSigners, filepath.Join("keys", "allowed_signers")) {
+			t.Errorf("allowed_signers = %q, want an absolute path ending in keys/allowed_signers", v.AllowedSigners)
+		}
+	})
+
+	t.Run("empty verify block", func(t *testing.T) {
+		content := `
+version = 1
+
+[[modules]]
+name = "foo"
+repo = "https://github.com/example/foo"
+revision = "main"
+dest = "vendor/foo"
+paths = [{ src = "src" }]
+
+[modules.verify]
+`
+		path := writeTempConfig(t, content)
+		_, err := Load(path)
+		if err == nil {
+			t.Fatal("expected error for verify block without keys")
+		}
+	})
+
+	t.Run("invalid tree pin", func(t *testing.T) {
+		for _, tree := range []string{"abc", "sha256:abc", "sha256:0123456789abcdef0123456789abcdef01234567"} {
+			content := `
+version = 1
+
+[[modules]]
+name = "foo"
+repo = "https://github.com/example/foo"
+revision = "main"
+dest = "vendor/foo"
+paths = [{ src = "src", tree = "` + tree + `" }]
+`
+			path := writeTempConfig(t, content)
+			if _, err := Load(path); err == nil {
+				t.Errorf("expected error for tree %q", tree)
+			}
+		}
+	})
+
+	t.Run("jobs", func(t *testing.T) {
+		content := `
+jobs = 4
+jobs_per_host = 2
+
+[[modules]]
+name = "foo"
+repo = "https://github.com/example/foo"
+revision = "main"
+dest = "vendor/foo"
+paths = [{ src = "src" }]
+`
+		path := writeTempConfig(t, content)
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Jobs != 4 || cfg.JobsPerHost != 2 {
+			t.Errorf("jobs = %d, jobs_per_host = %d, want 4, 2", cfg.Jobs, cfg.JobsPerHost)
+		}
+	})
+
+	t.Run("negative jobs", func(t *testing.T) {
+		for _, key := range []string{"jobs", "jobs_per_host"} {
+			path := writeTempConfig(t, key+" = -1\n")
+			if _, err := Load(path); err == nil {
+				t.Errorf("expected error for negative %s", key)
+			}
+		}
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		content := `
+[retry]
+attempts = 5
+initial_delay = "500ms"
+max_delay = "1m"
+`
+		path := writeTempConfig(t, content)
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		want := RetryPolicy{Attempts: 5, InitialDelay: 500 * time.Millisecond, MaxDelay: time.Minute}
+		if cfg.Retry != want {
+			t.Errorf("retry = %+v, want %+v", cfg.Retry, want)
+		}
+
+		path = writeTempConfig(t, "[retry]\nattempts = -1\n")
+		if _, err := Load(path); err == nil {
+			t.Error("expected error for negative attempts")
+		}
+	})
+
+	t.Run("timeouts", func(t *testing.T) {
+		content := `
+timeout = "10m"
+git_timeout = "1m"
+
+[[modules]]
+name = "foo"
+repo = "https://github.com/example/foo"
+revision = "main"
+dest = "vendor/foo"
+paths = [{ src = "src" }]
+
+[[modules]]
+name = "bar"
+repo = "https://github.com/example/bar"
+revision = "main"
+dest = "vendor/bar"
+paths = [{ src = "src" }]
+timeout = "30m"
+`
+		path := writeTempConfig(t, content)
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		foo, bar := cfg.Modules[0], cfg.Modules[1]
+		if foo.Timeout != 10*time.Minute || foo.GitTimeout != time.Minute {
+			t.Errorf("foo timeouts = %v, %v, want 10m, 1m", foo.Timeout, foo.GitTimeout)
+		}
+		if bar.Timeout != 30*time.Minute || bar.GitTimeout != time.Minute {
+			t.Errorf("bar timeouts = %v, %v, want 30m, 1m", bar.Timeout, bar.GitTimeout)
+		}
+	})
+
+	t.Run("rewrite rules apply to repo and mirrors", func(t *testing.T) {
+		t.Setenv("DEMOD_TEST_CI", "1")
+		content := `
+[[rewrite]]
+from = "https://github.com/"
+to = "https://mirror.internal/github/"
+env = "DEMOD_TEST_CI"
+
+[[modules]]
+name = "foo"
+repo = "https://github.com/example/foo"
+mirrors = ["https://backup.internal/foo.git"]
+revision = "main"
+dest = "vendor/foo"
+paths = [{ src = "src" }]
+`
+		path := writeTempConfig(t, content)
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		mod := cfg.Modules[0]
+		if mod.Repo != "https://github.com/example/foo" {
+			t.Errorf("repo = %q, want it unchanged", mod.Repo)
+		}
+		want := []string{"https://mirror.internal/github/example/foo", "https://backup.internal/foo.git"}
+		if !slices.Equal(mod.URLs, want) {
+			t.Errorf("urls = %v, want %v", mod.URLs, want)
+		}
+	})
+
+	t.Run("rewrite requires from and to", func(t *testing.T) {
+		path := writeTempConfig(t, "[[rewrite]]\nfrom = \"https://github.com/\"\n")
+		if _, err := Load(path); err == nil {
+			t.Fatal("expected error for rewrite without to")
+		}
+	})
+
+	t.Run("auth block is parsed with an absolute key path", func(t *testing.T) {
+		content := `
+[[modules]]
+name = "foo"
+repo = "git@github.com:example/foo.git"
+revision = "main"
+dest = "vendor/foo"
+paths = [{ src = "src" }]
+
+[modules.auth]
+ssh_key = "keys/deploy"
+`
+		path := writeTempConfig(t, content)
+		cfg, err := Load(path)
+		if err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		a := cfg.Modules[0].Auth
+		if a == nil {
+			t.Fatal("expected auth block")
+		}
+		if !filepath.IsAbs(a.SSHKey) || !strings.HasSuffix(a.SSHKey, filepath.Join("keys", "deploy")) {
+			t.Errorf("ssh_key = %q, want an absolute path ending in keys/deploy", a.SSHKey)
+		}
+	})
+
+	t.Run("invalid auth block", func(t *testing.T) {
+		for _, auth := range []string{
+			``,
+			`token_env = "DEPLOY TOKEN"`,
+			`token_env = "TOKEN"` + "\n" + `credential_helper = "store"`,
+			`token_user = "bot"`,
+		} {
+			content := `
+[[modules]]
+name = "foo"
+repo = "https://github.com/example/foo"
+revision = "main"
+dest = "vendor/foo"
+paths = [{ src = "src" }]
+
+[modules.auth]
+` + auth + "\n"
+			path := writeTempConfig(t, content)
+			if _, err := Load(path); err == nil {
+				t.Errorf("expected error for auth block %q", auth)
+			}
+		}
+	})
+
+	t.Run("token auth requires an http repo", func(t *testing.T) {
+		content := `
+[[modules]]
+name = "foo"
+repo = "git@github.com:example/foo.git"
+revision = "main"
+dest = "vendor/foo"
+paths = [{ src = "src" }]
+
+[modules.auth]
+token_env = "FOO_TOKEN"
+`
+		path := writeTempConfig(t, content)
+		if _, err := Load(path); err == nil {
+			t.Fatal("expected error for token_env with an SSH repo")
+		}
+	})
+
 	t.Run("file not found", func(t *testing.T) {
 		_, err := Load("/nonexistent/path/demod.toml")
 		if err == nil {
@@ -0,0 +1,143 @@
+package demod
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// stageDir creates an empty staging directory next to dest.
+// Keeping it on the same filesystem as dest lets replaceDir move it into place with a rename.
+func stageDir(dest string) (string, error) {
+	parent := filepath.Dir(dest)
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return "", err
+	}
+	stage, err := os.MkdirTemp(parent, "."+filepath.Base(dest)+".demod-stage-*")
+	if err != nil {
+		return "", err
+	}
+	if err := os.Chmod(stage, 0o755); err != nil {
+		_ = os.RemoveAll(stage)
+		return "", err
+	}
+	return stage, nil
+}
+
+// carryOver links every file in dest that demod does not own into stage, so that replacing dest
+// with stage keeps them. demod owns the files in managed and, only for a dest that has no manifest
+// yet, every file under the destination of one of paths; carryOver returns the files owned through
+// paths, which the swap removes.
+// A missing dest has nothing to carry over.
+func carryOver(dest, stage string, managed Manifest, paths []Path) (dropped []string, err error) {
+	err = filepath.WalkDir(dest, func(fpath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if fpath == dest && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dest, fpath)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if _, ok := managed[name]; ok || rel == ManifestFileName {
+			return nil
+		}
+		if ownedPath(paths, name) {
+			dropped = append(dropped, name)
+			return nil
+		}
+
+		target := filepath.Join(stage, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			link, err := os.Readlink(fpath)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+		return linkOrCopy(fpath, target)
+	})
+	return dropped, err
+}
+
+// ownedPath reports whether the slash-separated path name, relative to the module dest, is under
+// the destination of one of paths.
+func ownedPath(paths []Path, name string) bool {
+	for _, p := range paths {
+		dest := path.Clean(filepath.ToSlash(p.dest()))
+		if dest == "." || name == dest || strings.HasPrefix(name, dest+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// linkOrCopy hard-links src to dst, falling back to a copy if the filesystem does not support links.
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return copyFile(src, dst)
+}
+
+// replaceDir replaces dest with stage. An existing dest is moved aside first and
+// restored if stage cannot be moved into place, so dest always holds either its
+// previous or its new contents.
+func replaceDir(stage, dest string) error {
+	parent := filepath.Dir(dest)
+	backupDir, err := os.MkdirTemp(parent, "."+filepath.Base(dest)+".demod-backup-*")
+	if err != nil {
+		return err
+	}
+	backup := filepath.Join(backupDir, filepath.Base(dest))
+
+	hadDest := true
+	if err := os.Rename(dest, backup); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			_ = os.RemoveAll(backupDir)
+			return fmt.Errorf("moving %s aside: %w", dest, err)
+		}
+		hadDest = false
+	}
+
+	if err := os.Rename(stage, dest); err != nil {
+		if hadDest {
+			if rerr := os.Rename(backup, dest); rerr != nil {
+				return errors.Join(
+					fmt.Errorf("replacing %s: %w", dest, err),
+					fmt.Errorf("restoring %s (previous contents kept in %s): %w", dest, backup, rerr),
+				)
+			}
+		}
+		_ = os.RemoveAll(backupDir)
+		return fmt.Errorf("replacing %s: %w", dest, err)
+	}
+
+	_ = os.RemoveAll(backupDir)
+	return nil
+}
+
+// staleFiles returns the files in previous that are not in current.
+func staleFiles(previous, current Manifest) []string {
+	var stale []string
+	for _, name := range previous.files() {
+		if _, ok := current[name]; !ok {
+			stale = append(stale, name)
+		}
+	}
+	return stale
+}
@@ -0,0 +1,155 @@
+package demod
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCarryOver(t *testing.T) {
+	dest := t.TempDir()
+	stage := t.TempDir()
+	writeFiles(t, dest, map[string]string{
+		"lib/a.txt":       "aaa",
+		"lib/BUILD.bazel": "build",
+		"old/b.txt":       "bbb",
+		ManifestFileName:  "",
+	})
+	if err := os.Symlink("lib", filepath.Join(dest, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	dropped, err := carryOver(dest, stage, Manifest{"lib/a.txt": sumA, "old/b.txt": sumB}, nil)
+	if err != nil {
+		t.Fatalf("carryOver: %v", err)
+	}
+
+	if len(dropped) != 0 {
+		t.Errorf("dropped = %v, want none", dropped)
+	}
+	assertFileContent(t, filepath.Join(stage, "lib", "BUILD.bazel"), "build")
+	for _, name := range []string{"lib/a.txt", "old/b.txt", ManifestFileName} {
+		if _, err := os.Stat(filepath.Join(stage, name)); !os.IsNotExist(err) {
+			t.Errorf("expected %s to not be carried over, got err: %v", name, err)
+		}
+	}
+	if link, err := os.Readlink(filepath.Join(stage, "link")); err != nil || link != "lib" {
+		t.Errorf("link = %q, %v, want %q", link, err, "lib")
+	}
+}
+
+func TestCarryOver_OwnedPaths(t *testing.T) {
+	dest := t.TempDir()
+	stage := t.TempDir()
+	writeFiles(t, dest, map[string]string{
+		"lib/a.txt":   "aaa",
+		"BUILD.bazel": "build",
+	})
+
+	dropped, err := carryOver(dest, stage, Manifest{}, []Path{{Src: "src/lib", As: "lib"}})
+	if err != nil {
+		t.Fatalf("carryOver: %v", err)
+	}
+
+	if !slices.Equal(dropped, []string{"lib/a.txt"}) {
+		t.Errorf("dropped = %v, want [lib/a.txt]", dropped)
+	}
+	assertFileContent(t, filepath.Join(stage, "BUILD.bazel"), "build")
+	if _, err := os.Stat(filepath.Join(stage, "lib", "a.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected lib/a.txt to not be carried over, got err: %v", err)
+	}
+}
+
+func TestCarryOver_MissingDest(t *testing.T) {
+	if _, err := carryOver(filepath.Join(t.TempDir(), "missing"), t.TempDir(), nil, nil); err != nil {
+		t.Fatalf("carryOver: %v", err)
+	}
+}
+
+func TestOwnedPath(t *testing.T) {
+	paths := []Path{{Src: "src/lib", As: "lib"}, {Src: "docs/"}}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"lib/a.txt", true},
+		{"lib/sub/b.txt", true},
+		{"docs/readme.txt", true},
+		{"library.txt", false},
+		{"BUILD.bazel", false},
+		{"src/lib/a.txt", false},
+	}
+	for _, tt := range tests {
+		if got := ownedPath(paths, tt.name); got != tt.want {
+			t.Errorf("ownedPath(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if !ownedPath([]Path{{Src: "."}}, "BUILD.bazel") {
+		t.Error("expected every file to be owned by a path synced to the dest root")
+	}
+}
+
+func TestReplaceDir(t *testing.T) {
+	t.Run("existing dest", func(t *testing.T) {
+		parent := t.TempDir()
+		dest := filepath.Join(parent, "dest")
+		writeFiles(t, dest, map[string]string{"old.txt": "old"})
+
+		stage, err := stageDir(dest)
+		if err != nil {
+			t.Fatalf("stageDir: %v", err)
+		}
+		writeFiles(t, stage, map[string]string{"new.txt": "new"})
+
+		if err := replaceDir(stage, dest); err != nil {
+			t.Fatalf("replaceDir: %v", err)
+		}
+		assertFileContent(t, filepath.Join(dest, "new.txt"), "new")
+		if _, err := os.Stat(filepath.Join(dest, "old.txt")); !os.IsNotExist(err) {
+			t.Errorf("expected old.txt to be replaced, got err: %v", err)
+		}
+
+		entries, err := os.ReadDir(parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("expected no leftover staging or backup dirs, got %d entries", len(entries))
+		}
+	})
+
+	t.Run("missing dest", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "nested", "dest")
+
+		stage, err := stageDir(dest)
+		if err != nil {
+			t.Fatalf("stageDir: %v", err)
+		}
+		writeFiles(t, stage, map[string]string{"new.txt": "new"})
+
+		if err := replaceDir(stage, dest); err != nil {
+			t.Fatalf("replaceDir: %v", err)
+		}
+		assertFileContent(t, filepath.Join(dest, "new.txt"), "new")
+	})
+
+	t.Run("missing stage restores dest", func(t *testing.T) {
+		parent := t.TempDir()
+		dest := filepath.Join(parent, "dest")
+		writeFiles(t, dest, map[string]string{"old.txt": "old"})
+
+		if err := replaceDir(filepath.Join(parent, "missing"), dest); err == nil {
+			t.Fatal("expected error for missing stage")
+		}
+		assertFileContent(t, filepath.Join(dest, "old.txt"), "old")
+	})
+}
+
+func TestStaleFiles(t *testing.T) {
+	previous := Manifest{"a.txt": sumA, "b.txt": sumB}
+	current := Manifest{"a.txt": sumA, "c.txt": sumB}
+	if got := staleFiles(previous, current); !slices.Equal(got, []string{"b.txt"}) {
+		t.Errorf("staleFiles = %v, want [b.txt]", got)
+	}
+}
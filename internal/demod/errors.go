@@ -0,0 +1,77 @@
+package demod
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Stages of a module sync reported by StageError.
+const (
+	// StagePrepare creates the temporary and staging directories and reads the current manifest.
+	StagePrepare = "prepare"
+	// StageFetch resolves the module revision and fetches its commit into the mirror.
+	StageFetch = "fetch"
+	// StageVerify checks commit signatures and pinned trees.
+	StageVerify = "verify"
+	// StageSparseCheckout creates the worktree and sets its sparse paths.
+	StageSparseCheckout = "sparse-checkout"
+	// StageCheckout checks out the module commit.
+	StageCheckout = "checkout"
+	// StageCopy copies the module paths out of the checkout.
+	StageCopy = "copy"
+	// StageWrite writes the manifest and swaps the staged dest into place.
+	StageWrite = "write"
+)
+
+// StageError is returned when syncing or checking a module fails.
+type StageError struct {
+	Module string
+	// Stage is the stage that failed, or empty if the module did not get to run.
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	if e.Stage == "" {
+		return fmt.Sprintf("[%s] %v", e.Module, e.Err)
+	}
+	return fmt.Sprintf("[%s] %s: %v", e.Module, e.Stage, e.Err)
+}
+
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
+
+func stageError(mod Module, stage string, err error) error {
+	return &StageError{Module: mod.Name, Stage: stage, Err: err}
+}
+
+// ModuleErrors is returned in keep-going mode when one or more modules failed.
+// It holds one error per failed module, in config order.
+type ModuleErrors []*StageError
+
+func (e ModuleErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e ModuleErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// failed reports whether the module named name is among e.
+func (e ModuleErrors) failed(name string) bool {
+	for _, err := range e {
+		if err.Module == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package demod
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+func TestStageError(t *testing.T) {
+	t.Run("message names module and stage", func(t *testing.T) {
+		err := stageError(Module{Name: "foo"}, StageCheckout, fs.ErrNotExist)
+		if got, want := err.Error(), "[foo] checkout: file does not exist"; got != want {
+			t.Errorf("Error() = %q, want %q", got, want)
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Error("expected error to wrap fs.ErrNotExist")
+		}
+	})
+
+	t.Run("without stage", func(t *testing.T) {
+		err := &StageError{Module: "foo", Err: fs.ErrNotExist}
+		if got, want := err.Error(), "[foo] file does not exist"; got != want {
+			t.Errorf("Error() = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestModuleErrors(t *testing.T) {
+	errs := ModuleErrors{
+		{Module: "foo", Stage: StageFetch, Err: errors.New("not found")},
+		{Module: "bar", Stage: StageCopy, Err: fs.ErrPermission},
+	}
+	if got, want := errs.Error(), "[foo] fetch: not found\n[bar] copy: permission denied"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if !errors.Is(errs, fs.ErrPermission) {
+		t.Error("expected errors to wrap fs.ErrPermission")
+	}
+	if !errs.failed("bar") || errs.failed("baz") {
+		t.Error("failed reports the wrong modules")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package demod
+
+import (
+	"errors"
+	"os"
+)
+
+// errLocked is returned by tryLockFile if another process holds the lock.
+var errLocked = errors.New("locked by another process")
+
+// tryLockFile is a no-op on platforms without flock; mirrors are then only locked within the process.
+func tryLockFile(f *os.File) error { return nil }
@@ -0,0 +1,22 @@
+//go:build unix
+
+package demod
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// errLocked is returned by tryLockFile if another process holds the lock.
+var errLocked = errors.New("locked by another process")
+
+// tryLockFile takes an exclusive advisory lock on f without blocking.
+// The lock is released when f is closed, including when the process exits.
+func tryLockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return errLocked
+	}
+	return err
+}
@@ -1,36 +1,233 @@
 package demod
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"log/slog"
+	"os"
 	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func runGit(logger *slog.Logger, workdir string, args ...string) error {
+// gitWaitDelay is how long an interrupted git process gets to exit before it is killed.
+const gitWaitDelay = 5 * time.Second
+
+type gitEnvKey struct{}
+
+// withGitEnv returns a context in which every git command runs with env added to its environment.
+func withGitEnv(ctx context.Context, env ...string) context.Context {
+	return context.WithValue(ctx, gitEnvKey{}, append(gitEnv(ctx), env...))
+}
+
+// gitEnv returns the environment added by withGitEnv.
+func gitEnv(ctx context.Context) []string {
+	env, _ := ctx.Value(gitEnvKey{}).([]string)
+	return slices.Clip(env)
+}
+
+type gitConfigKey struct{}
+
+// withGitConfig returns a context in which every git command runs with the configuration variable key
+// set to value. Values are passed in the environment, so they never show up in process arguments.
+// Setting a multi-valued key to "" resets the values configured before.
+func withGitConfig(ctx context.Context, key, value string) context.Context {
+	config, _ := ctx.Value(gitConfigKey{}).([][2]string)
+	return context.WithValue(ctx, gitConfigKey{}, append(slices.Clip(config), [2]string{key, value}))
+}
+
+// gitCommandEnv returns the environment added to git by withGitEnv and withGitConfig.
+// Configuration already passed to demod in GIT_CONFIG_COUNT is kept.
+func gitCommandEnv(ctx context.Context) []string {
+	env := gitEnv(ctx)
+	config, _ := ctx.Value(gitConfigKey{}).([][2]string)
+	if len(config) == 0 {
+		return env
+	}
+	n, _ := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+	for _, kv := range config {
+		env = append(env, fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", n, kv[0]), fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", n, kv[1]))
+		n++
+	}
+	return append(env, fmt.Sprintf("GIT_CONFIG_COUNT=%d", n))
+}
+
+func runGit(ctx context.Context, logger *slog.Logger, workdir string, args ...string) error {
+	_, err := gitOutput(ctx, logger, workdir, args...)
+	return err
+}
+
+// gitOutput runs git and returns its standard output.
+// Commands that talk to a remote are retried after transient failures, as configured by withRetry.
+func gitOutput(ctx context.Context, logger *slog.Logger, workdir string, args ...string) (string, error) {
+	var out string
+	run := func() error {
+		var err error
+		out, err = execGit(ctx, logger, workdir, args...)
+		return err
+	}
+	var err error
+	if networkCommands[args[0]] {
+		err = retryPolicy(ctx).do(ctx, logger, run)
+	} else {
+		err = run()
+	}
+	return out, err
+}
+
+// execGit runs git once and returns its standard output.
+func execGit(ctx context.Context, logger *slog.Logger, workdir string, args ...string) (string, error) {
 	logger.Debug("exec", "cmd", "git", "args", args)
-	cmd := exec.Command("git", args...)
+	cmdCtx := ctx
+	if d := gitTimeout(ctx); d > 0 {
+		var cancel context.CancelFunc
+		cmdCtx, cancel = context.WithTimeoutCause(ctx, d, &TimeoutError{Op: "git " + args[0], Timeout: d})
+		defer cancel()
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(cmdCtx, "git", args...)
 	cmd.Dir = workdir
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("git %s: %w\n%s", args[0], err, out)
+	// Retries and offline cache misses are detected from the messages of git, so keep them untranslated.
+	cmd.Env = append(append(os.Environ(), gitCommandEnv(ctx)...), "LC_ALL=C")
+	// Interrupt git rather than killing it, so it removes its lock files and temporary packs.
+	setProcessGroup(cmd)
+	cmd.WaitDelay = gitWaitDelay
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if cmdCtx.Err() != nil {
+			return "", fmt.Errorf("git %s: %w", args[0], context.Cause(cmdCtx))
+		}
+		return "", fmt.Errorf("git %s: %w\n%s", args[0], err, stderr.Bytes())
 	}
-	logger.Debug("output", "result", string(out))
-	return nil
+	logger.Debug("output", "result", stdout.String())
+	return stdout.String(), nil
+}
+
+// gitInitMirror creates a bare partial clone of repo at dir without fetching anything.
+// Blobs are fetched lazily from origin when a worktree needs them and kept in the mirror.
+// Since origin is pointed at whichever URL of the module is being fetched from, repo is also
+// recorded as demod.repo.
+func gitInitMirror(ctx context.Context, logger *slog.Logger, dir, repo string) error {
+	if err := runGit(ctx, logger, "", "init", "--bare", dir); err != nil {
+		return err
+	}
+	if err := runGit(ctx, logger, dir, "config", "demod.repo", repo); err != nil {
+		return err
+	}
+	if err := runGit(ctx, logger, dir, "remote", "add", "origin", repo); err != nil {
+		return err
+	}
+	if err := runGit(ctx, logger, dir, "config", "remote.origin.promisor", "true"); err != nil {
+		return err
+	}
+	return runGit(ctx, logger, dir, "config", "remote.origin.partialclonefilter", "blob:none")
+}
+
+// gitRemoteSetURL points origin of the repository at gitDir to url.
+func gitRemoteSetURL(ctx context.Context, logger *slog.Logger, gitDir, url string) error {
+	return runGit(ctx, logger, gitDir, "remote", "set-url", "origin", url)
+}
+
+func gitWorktreeAdd(ctx context.Context, logger *slog.Logger, mirror, workdir, commit string) error {
+	return runGit(ctx, logger, mirror, "worktree", "add", "--no-checkout", "--detach", workdir, commit)
 }
 
-func gitClone(logger *slog.Logger, repo, workdir string) error {
-	return runGit(logger, "", "clone", "--filter=blob:none", "--no-checkout", "--depth", "1", repo, workdir)
+func gitWorktreePrune(ctx context.Context, logger *slog.Logger, mirror string) error {
+	return runGit(ctx, logger, mirror, "worktree", "prune")
 }
 
-func gitSparseCheckoutInit(logger *slog.Logger, workdir string) error {
-	return runGit(logger, workdir, "sparse-checkout", "init", "--cone")
+func gitSparseCheckoutInit(ctx context.Context, logger *slog.Logger, workdir string) error {
+	return runGit(ctx, logger, workdir, "sparse-checkout", "init", "--cone")
 }
 
-func gitSparseCheckoutSet(logger *slog.Logger, workdir string, paths []string) error {
+func gitSparseCheckoutSet(ctx context.Context, logger *slog.Logger, workdir string, paths []string) error {
 	args := append([]string{"sparse-checkout", "set"}, paths...)
-	return runGit(logger, workdir, args...)
+	return runGit(ctx, logger, workdir, args...)
+}
+
+// gitLsRemote lists the refs advertised by origin, mapping each ref name to the object it points to.
+// Annotated tags are mapped to the commit they point to.
+func gitLsRemote(ctx context.Context, logger *slog.Logger, gitDir string) (map[string]string, error) {
+	out, err := gitOutput(ctx, logger, gitDir, "ls-remote", "origin")
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		id, name, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		// The peeled entry of a tag always follows the tag itself.
+		name = strings.TrimSuffix(name, "^{}")
+		refs[name] = id
+	}
+	return refs, nil
+}
+
+// gitFetchShallow fetches src, a ref or commit ID, from origin with depth 1 and stores it as dst.
+func gitFetchShallow(ctx context.Context, logger *slog.Logger, gitDir, src, dst string) error {
+	return runGit(ctx, logger, gitDir, "fetch", "--filter=blob:none", "--depth", "1", "--no-tags", "origin", "+"+src+":"+dst)
+}
+
+// gitFetchAll fetches the full history of every branch and tag from origin,
+// deepening the history of earlier shallow fetches.
+func gitFetchAll(ctx context.Context, logger *slog.Logger, gitDir string) error {
+	args := []string{"fetch", "--filter=blob:none", "--no-tags"}
+	out, err := gitOutput(ctx, logger, gitDir, "rev-parse", "--is-shallow-repository")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(out) == "true" {
+		args = append(args, "--unshallow")
+	}
+	args = append(args, "origin", "+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*")
+	return runGit(ctx, logger, gitDir, args...)
+}
+
+// gitHasCommit reports whether commit is present in the repository at gitDir.
+// Unlike most commands, rev-list --missing does not fetch missing objects from a promisor remote.
+func gitHasCommit(ctx context.Context, logger *slog.Logger, gitDir, commit string) bool {
+	return runGit(ctx, logger, gitDir, "rev-list", "--missing=print", "--no-walk", commit+"^{commit}") == nil
+}
+
+// gitUpdateRef points ref at commit in the repository at gitDir.
+func gitUpdateRef(ctx context.Context, logger *slog.Logger, gitDir, ref, commit string) error {
+	return runGit(ctx, logger, gitDir, "update-ref", ref, commit)
+}
+
+func gitCheckout(ctx context.Context, logger *slog.Logger, workdir, revision string) error {
+	return runGit(ctx, logger, workdir, "checkout", revision)
 }
 
-func gitCheckout(logger *slog.Logger, workdir, revision string) error {
-	return runGit(logger, workdir, "checkout", revision)
+// gitLocalRefs lists the refs of the repository at gitDir like gitLsRemote lists those of origin.
+func gitLocalRefs(ctx context.Context, logger *slog.Logger, gitDir string) (map[string]string, error) {
+	out, err := gitOutput(ctx, logger, gitDir, "for-each-ref", "--format=%(objectname) %(*objectname) %(refname)")
+	if err != nil {
+		return nil, err
+	}
+	refs := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 2:
+			refs[fields[1]] = fields[0]
+		case 3:
+			// Annotated tags are followed by the commit they point to.
+			refs[fields[2]] = fields[1]
+		}
+	}
+	return refs, nil
+}
+
+func gitRevParse(ctx context.Context, logger *slog.Logger, workdir, revision string) (string, error) {
+	out, err := gitOutput(ctx, logger, workdir, "rev-parse", "--verify", revision+"^{commit}")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
 }
@@ -1,10 +1,14 @@
 package demod
 
 import (
+	"context"
+	"errors"
 	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 )
 
@@ -16,51 +20,155 @@ func TestRunGit(t *testing.T) {
 		if err := exec.Command("git", "init", dir).Run(); err != nil {
 			t.Fatal(err)
 		}
-		if err := runGit(logger, dir, "status"); err != nil {
+		if err := runGit(t.Context(), logger, dir, "status"); err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
 	})
 
 	t.Run("error", func(t *testing.T) {
 		dir := t.TempDir()
-		if err := runGit(logger, dir, "checkout", "nonexistent"); err == nil {
+		if err := runGit(t.Context(), logger, dir, "checkout", "nonexistent"); err == nil {
 			t.Fatal("expected error for invalid git command")
 		}
 	})
+
+	t.Run("messages are not translated", func(t *testing.T) {
+		t.Setenv("LANG", "de_DE.UTF-8")
+		t.Setenv("LC_ALL", "de_DE.UTF-8")
+		t.Setenv("LANGUAGE", "de")
+		out, err := gitOutput(t.Context(), logger, t.TempDir(), "-c", "alias.printenv=!env", "printenv")
+		if err != nil {
+			t.Fatalf("gitOutput: %v", err)
+		}
+		if !slices.Contains(strings.Split(out, "\n"), "LC_ALL=C") {
+			t.Errorf("git environment does not set LC_ALL=C:\n%s", out)
+		}
+		err = runGit(t.Context(), logger, t.TempDir(), "status")
+		if err == nil || !strings.Contains(err.Error(), "not a git repository") {
+			t.Errorf("err = %v, want the untranslated git message", err)
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		err := runGit(ctx, logger, t.TempDir(), "init")
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("err = %v, want context.Canceled", err)
+		}
+	})
 }
 
-func TestGitClone(t *testing.T) {
+func TestGitInitMirror(t *testing.T) {
 	logger := slog.Default()
 	bare := setupBareRepo(t)
-	workdir := filepath.Join(t.TempDir(), "repo")
+	mirror := filepath.Join(t.TempDir(), "mirror.git")
 
-	if err := gitClone(logger, bare, workdir); err != nil {
-		t.Fatalf("gitClone: %v", err)
+	if err := gitInitMirror(t.Context(), logger, mirror, bare); err != nil {
+		t.Fatalf("gitInitMirror: %v", err)
 	}
 
-	if _, err := os.Stat(filepath.Join(workdir, ".git")); err != nil {
-		t.Fatalf("expected .git dir in cloned repo: %v", err)
+	url, err := gitOutput(t.Context(), logger, mirror, "config", "--get", "remote.origin.url")
+	if err != nil {
+		t.Fatalf("reading remote url: %v", err)
+	}
+	if strings.TrimSpace(url) != bare {
+		t.Errorf("remote url = %q, want %q", strings.TrimSpace(url), bare)
+	}
+}
+
+func TestGitLsRemote(t *testing.T) {
+	logger := slog.Default()
+	bare := setupBareRepo(t)
+	mirror := setupMirror(t, bare)
+	main := gitTestOutput(t, bare, "rev-parse", "main")
+	gitTestOutput(t, bare, "-c", "user.email=test@test.com", "-c", "user.name=Test", "tag", "-a", "-m", "v1", "v1", "main")
+
+	refs, err := gitLsRemote(t.Context(), logger, mirror)
+	if err != nil {
+		t.Fatalf("gitLsRemote: %v", err)
+	}
+	for _, ref := range []string{"HEAD", "refs/heads/main", "refs/tags/v1"} {
+		if refs[ref] != main {
+			t.Errorf("refs[%q] = %q, want %q", ref, refs[ref], main)
+		}
+	}
+}
+
+func TestGitFetchShallow(t *testing.T) {
+	logger := slog.Default()
+	bare := setupBareRepo(t)
+	mirror := setupMirror(t, bare)
+
+	if err := gitFetchShallow(t.Context(), logger, mirror, "main", "refs/heads/main"); err != nil {
+		t.Fatalf("gitFetchShallow: %v", err)
+	}
+
+	sha, err := gitRevParse(t.Context(), logger, mirror, "main")
+	if err != nil {
+		t.Fatalf("gitRevParse: %v", err)
+	}
+	if len(sha) != 40 {
+		t.Errorf("sha = %q, want a full commit SHA", sha)
+	}
+
+	if err := gitFetchShallow(t.Context(), logger, mirror, "nonexistent", "refs/heads/nonexistent"); err == nil {
+		t.Fatal("expected error for unknown revision")
+	}
+}
+
+func TestGitFetchAll(t *testing.T) {
+	logger := slog.Default()
+	bare := setupBareRepo(t)
+	first := gitTestOutput(t, bare, "rev-parse", "main")
+	pushCommit(t, bare, map[string]string{"src/lib/a.txt": "aaa2"})
+	mirror := setupMirror(t, bare)
+
+	if err := gitFetchShallow(t.Context(), logger, mirror, "main", "refs/heads/main"); err != nil {
+		t.Fatalf("gitFetchShallow: %v", err)
+	}
+	if gitHasCommit(t.Context(), logger, mirror, first) {
+		t.Fatal("expected shallow fetch to leave out the parent commit")
+	}
+
+	if err := gitFetchAll(t.Context(), logger, mirror); err != nil {
+		t.Fatalf("gitFetchAll: %v", err)
+	}
+	if !gitHasCommit(t.Context(), logger, mirror, first) {
+		t.Error("expected full fetch to include the parent commit")
+	}
+	if err := gitFetchAll(t.Context(), logger, mirror); err != nil {
+		t.Fatalf("gitFetchAll on a complete mirror: %v", err)
 	}
 }
 
 func TestGitSparseCheckout(t *testing.T) {
 	logger := slog.Default()
 	bare := setupBareRepo(t)
+	mirror := setupMirror(t, bare)
 	workdir := filepath.Join(t.TempDir(), "repo")
 
-	if err := gitClone(logger, bare, workdir); err != nil {
-		t.Fatalf("gitClone: %v", err)
+	if err := gitFetchShallow(t.Context(), logger, mirror, "main", "refs/heads/main"); err != nil {
+		t.Fatalf("gitFetchShallow: %v", err)
+	}
+	sha, err := gitRevParse(t.Context(), logger, mirror, "main")
+	if err != nil {
+		t.Fatalf("gitRevParse: %v", err)
+	}
+
+	if err := gitWorktreeAdd(t.Context(), logger, mirror, workdir, sha); err != nil {
+		t.Fatalf("gitWorktreeAdd: %v", err)
 	}
 
-	if err := gitSparseCheckoutInit(logger, workdir); err != nil {
+	if err := gitSparseCheckoutInit(t.Context(), logger, workdir); err != nil {
 		t.Fatalf("gitSparseCheckoutInit: %v", err)
 	}
 
-	if err := gitSparseCheckoutSet(logger, workdir, []string{"src/lib"}); err != nil {
+	if err := gitSparseCheckoutSet(t.Context(), logger, workdir, []string{"src/lib"}); err != nil {
 		t.Fatalf("gitSparseCheckoutSet: %v", err)
 	}
 
-	if err := gitCheckout(logger, workdir, "main"); err != nil {
+	if err := gitCheckout(t.Context(), logger, workdir, sha); err != nil {
 		t.Fatalf("gitCheckout: %v", err)
 	}
 
@@ -75,6 +183,69 @@ func TestGitSparseCheckout(t *testing.T) {
 	}
 }
 
+func TestGitWorktreePrune(t *testing.T) {
+	logger := slog.Default()
+	bare := setupBareRepo(t)
+	mirror := setupMirror(t, bare)
+	workdir := filepath.Join(t.TempDir(), "repo")
+
+	if err := gitFetchShallow(t.Context(), logger, mirror, "main", "refs/heads/main"); err != nil {
+		t.Fatalf("gitFetchShallow: %v", err)
+	}
+	if err := gitWorktreeAdd(t.Context(), logger, mirror, workdir, "main"); err != nil {
+		t.Fatalf("gitWorktreeAdd: %v", err)
+	}
+	if err := os.RemoveAll(workdir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := gitWorktreePrune(t.Context(), logger, mirror); err != nil {
+		t.Fatalf("gitWorktreePrune: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(mirror, "worktrees")); !os.IsNotExist(err) {
+		t.Errorf("expected worktrees to be pruned, got err: %v", err)
+	}
+}
+
+func TestGitRevParse(t *testing.T) {
+	logger := slog.Default()
+	bare := setupBareRepo(t)
+
+	sha, err := gitRevParse(t.Context(), logger, bare, "main")
+	if err != nil {
+		t.Fatalf("gitRevParse: %v", err)
+	}
+	if len(sha) != 40 {
+		t.Errorf("sha = %q, want a full commit SHA", sha)
+	}
+
+	if _, err := gitRevParse(t.Context(), logger, bare, "nonexistent"); err == nil {
+		t.Fatal("expected error for unknown revision")
+	}
+}
+
+// gitTestOutput runs git in dir and returns its trimmed output.
+func gitTestOutput(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// setupMirror creates an empty mirror of the repo at bare.
+func setupMirror(t *testing.T, bare string) string {
+	t.Helper()
+	mirror := filepath.Join(t.TempDir(), "mirror.git")
+	if err := gitInitMirror(t.Context(), slog.Default(), mirror, bare); err != nil {
+		t.Fatalf("gitInitMirror: %v", err)
+	}
+	return mirror
+}
+
 // setupBareRepo creates a bare git repo with the following structure:
 //
 //	src/lib/a.txt  ("aaa")
@@ -128,3 +299,41 @@ func setupBareRepo(t *testing.T) string {
 
 	return bare
 }
+
+// pushCommit commits the given files on top of main in the bare repo and returns the new commit SHA.
+func pushCommit(t *testing.T, bare string, files map[string]string) string {
+	t.Helper()
+
+	workdir := filepath.Join(t.TempDir(), "work")
+	if out, err := exec.Command("git", "clone", bare, workdir).CombinedOutput(); err != nil {
+		t.Fatalf("cloning bare repo: %v\n%s", err, out)
+	}
+	run := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = workdir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+
+	run("config", "user.email", "test@test.com")
+	run("config", "user.name", "Test")
+
+	for path, content := range files {
+		abs := filepath.Join(workdir, path)
+		if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(abs, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run("add", "-A")
+	run("commit", "-m", "update")
+	run("push", "origin", "main")
+	return run("rev-parse", "HEAD")
+}
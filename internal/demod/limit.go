@@ -0,0 +1,134 @@
+package demod
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultJobs is the number of modules processed at once when no limit is configured.
+const DefaultJobs = 8
+
+// limiter bounds how many modules are processed at once, overall and per git host.
+type limiter struct {
+	all     chan struct{}
+	perHost int
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+// newLimiter returns a limiter allowing jobs modules at once, and perHost modules per host
+// if perHost is positive.
+func newLimiter(jobs, perHost int) *limiter {
+	return &limiter{all: make(chan struct{}, max(jobs, 1)), perHost: perHost, hosts: make(map[string]chan struct{})}
+}
+
+// acquire blocks until a module from repo may be processed, and returns a function that releases it.
+// The host slot is taken first, so modules waiting for a busy host do not hold back other hosts.
+func (l *limiter) acquire(ctx context.Context, repo string) (func(), error) {
+	var host chan struct{}
+	if l.perHost > 0 {
+		l.mu.Lock()
+		key := repoHost(repo)
+		host = l.hosts[key]
+		if host == nil {
+			host = make(chan struct{}, l.perHost)
+			l.hosts[key] = host
+		}
+		l.mu.Unlock()
+
+		select {
+		case host <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	select {
+	case l.all <- struct{}{}:
+	case <-ctx.Done():
+		if host != nil {
+			<-host
+		}
+		return nil, ctx.Err()
+	}
+	return func() {
+		<-l.all
+		if host != nil {
+			<-host
+		}
+	}, nil
+}
+
+// repoHost returns the host of a repository URL, including scp-like SSH addresses such as
+// git@github.com:org/repo.git. Local paths have no host and return "".
+func repoHost(repo string) string {
+	if u, err := url.Parse(repo); err == nil && strings.Contains(repo, "://") {
+		return u.Hostname()
+	}
+	if before, _, ok := strings.Cut(repo, ":"); ok && !strings.Contains(before, "/") && len(before) > 1 {
+		_, host, found := strings.Cut(before, "@")
+		if !found {
+			host = before
+		}
+		return host
+	}
+	return ""
+}
+
+// forEachModule calls fn for every module in cfg concurrently, within the job limits of opts.
+// The first error cancels the other modules and is returned, unless opts.KeepGoing is set:
+// then every module runs to completion and the errors of all failed modules are returned as ModuleErrors.
+func forEachModule(ctx context.Context, cfg *Config, opts SyncOptions, fn func(ctx context.Context, i int, mod Module) error) error {
+	limit := opts.limiter()
+	if !opts.KeepGoing {
+		g, ctx := errgroup.WithContext(ctx)
+		for i, mod := range cfg.Modules {
+			g.Go(func() error {
+				release, err := limit.acquire(ctx, mod.remotes()[0])
+				if err != nil {
+					return err
+				}
+				defer release()
+				return fn(ctx, i, mod)
+			})
+		}
+		return g.Wait()
+	}
+
+	errs := make([]error, len(cfg.Modules))
+	var wg sync.WaitGroup
+	for i, mod := range cfg.Modules {
+		wg.Go(func() {
+			release, err := limit.acquire(ctx, mod.remotes()[0])
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer release()
+			errs[i] = fn(ctx, i, mod)
+		})
+	}
+	wg.Wait()
+
+	var failed ModuleErrors
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		var se *StageError
+		if !errors.As(err, &se) {
+			se = &StageError{Module: cfg.Modules[i].Name, Err: err}
+		}
+		failed = append(failed, se)
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package demod
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRepoHost(t *testing.T) {
+	tests := []struct {
+		repo string
+		want string
+	}{
+		{"https://github.com/example/foo.git", "github.com"},
+		{"https://user@gitlab.example.com:8443/foo.git", "gitlab.example.com"},
+		{"ssh://git@github.com/example/foo.git", "github.com"},
+		{"git@github.com:example/foo.git", "github.com"},
+		{"github.com:example/foo.git", "github.com"},
+		{"file:///srv/git/foo.git", ""},
+		{"/srv/git/foo.git", ""},
+		{"../foo", ""},
+		{`C:\src\foo`, ""},
+	}
+	for _, tt := range tests {
+		if got := repoHost(tt.repo); got != tt.want {
+			t.Errorf("repoHost(%q) = %q, want %q", tt.repo, got, tt.want)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	// run acquires a slot for every repo at once and returns the highest number of
+	// slots held at the same time, overall and per repo.
+	run := func(t *testing.T, l *limiter, repos []string) (int32, map[string]int32) {
+		t.Helper()
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			cur     atomic.Int32
+			peak    atomic.Int32
+			curRepo = make(map[string]int32)
+			peakRep = make(map[string]int32)
+		)
+		for _, repo := range repos {
+			wg.Go(func() {
+				release, err := l.acquire(t.Context(), repo)
+				if err != nil {
+					t.Errorf("acquire: %v", err)
+					return
+				}
+				defer release()
+
+				n := cur.Add(1)
+				for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+				}
+				mu.Lock()
+				curRepo[repo]++
+				peakRep[repo] = max(peakRep[repo], curRepo[repo])
+				mu.Unlock()
+
+				time.Sleep(10 * time.Millisecond)
+
+				mu.Lock()
+				curRepo[repo]--
+				mu.Unlock()
+				cur.Add(-1)
+			})
+		}
+		wg.Wait()
+		return peak.Load(), peakRep
+	}
+
+	t.Run("limits overall", func(t *testing.T) {
+		repos := make([]string, 10)
+		for i := range repos {
+			repos[i] = "https://github.com/example/foo.git"
+		}
+		peak, _ := run(t, newLimiter(3, 0), repos)
+		if peak > 3 {
+			t.Errorf("peak = %d, want <= 3", peak)
+		}
+	})
+
+	t.Run("limits per host", func(t *testing.T) {
+		var repos []string
+		for range 6 {
+			repos = append(repos, "https://github.com/example/foo.git", "https://gitlab.com/example/bar.git")
+		}
+		peak, _ := run(t, newLimiter(10, 2), repos)
+		if peak > 4 {
+			t.Errorf("peak = %d, want <= 4", peak)
+		}
+		_, perRepo := run(t, newLimiter(10, 2), repos)
+		for repo, p := range perRepo {
+			if p > 2 {
+				t.Errorf("peak for %s = %d, want <= 2", repo, p)
+			}
+		}
+	})
+
+	t.Run("canceled while waiting", func(t *testing.T) {
+		l := newLimiter(1, 0)
+		release, err := l.acquire(t.Context(), "https://github.com/example/foo.git")
+		if err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		defer release()
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		if _, err := l.acquire(ctx, "https://gitlab.com/example/bar.git"); !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want context.Canceled", err)
+		}
+	})
+
+	t.Run("canceled waiting for host releases nothing", func(t *testing.T) {
+		l := newLimiter(2, 1)
+		release, err := l.acquire(t.Context(), "https://github.com/example/foo.git")
+		if err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		if _, err := l.acquire(ctx, "https://github.com/example/bar.git"); !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want context.Canceled", err)
+		}
+		release()
+		// Both slots must be free again.
+		r1, err := l.acquire(t.Context(), "https://github.com/example/foo.git")
+		if err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		r2, err := l.acquire(t.Context(), "https://gitlab.com/example/bar.git")
+		if err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+		r1()
+		r2()
+	})
+}
@@ -0,0 +1,159 @@
+package demod
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/BurntSushi/toml"
+)
+
+// LockFileName is the name of the lock file written next to the config file.
+const LockFileName = "demod.lock"
+
+const lockHeader = "# This file is generated by demod. Do not edit it by hand.\n\n"
+
+type Lock struct {
+	Version int            `toml:"version"`
+	Modules []LockedModule `toml:"modules"`
+}
+
+type LockedModule struct {
+	Name     string       `toml:"name"`
+	Repo     string       `toml:"repo"`
+	Revision string       `toml:"revision"`
+	Commit   string       `toml:"commit"`
+	Tag      string       `toml:"tag,omitempty"`
+	Paths    []LockedPath `toml:"paths"`
+}
+
+type LockedPath struct {
+	Src      string   `toml:"src"`
+	As       string   `toml:"as,omitempty"`
+	Exclude  []string `toml:"exclude,omitempty"`
+	Symlinks string   `toml:"symlinks,omitempty"`
+	Hash     string   `toml:"hash"`
+}
+
+// LockPath returns the lock file path for the given config file path.
+func LockPath(configPath string) string {
+	return filepath.Join(filepath.Dir(configPath), LockFileName)
+}
+
+// LoadLock reads the lock file at path.
+// A missing file is reported as an error wrapping fs.ErrNotExist.
+func LoadLock(path string) (*Lock, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading lock: %w", err)
+	}
+
+	var lock Lock
+	if err := toml.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("parsing lock: %w", err)
+	}
+
+	if lock.Version != 1 {
+		return nil, fmt.Errorf("unsupported lock version: %d (expected 1)", lock.Version)
+	}
+
+	return &lock, nil
+}
+
+// loadLockOrEmpty is like LoadLock but returns an empty lock when path is empty or does not exist.
+func loadLockOrEmpty(path string) (*Lock, error) {
+	if path == "" {
+		return &Lock{Version: 1}, nil
+	}
+	lock, err := LoadLock(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Lock{Version: 1}, nil
+	}
+	return lock, err
+}
+
+// loadFrozenLock loads the lock file at path and verifies that it is up to date with cfg.
+func loadFrozenLock(cfg *Config, path string) (*Lock, error) {
+	if path == "" {
+		return nil, errors.New("frozen sync requires a lock file")
+	}
+	lock, err := LoadLock(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := lock.verifyFrozen(cfg); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// Save writes the lock to path.
+func (l *Lock) Save(path string) error {
+	var buf bytes.Buffer
+	buf.WriteString(lockHeader)
+	if err := toml.NewEncoder(&buf).Encode(l); err != nil {
+		return fmt.Errorf("encoding lock: %w", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("writing lock: %w", err)
+	}
+	return nil
+}
+
+// Find returns the locked entry for the named module, or nil if there is none.
+func (l *Lock) Find(name string) *LockedModule {
+	for i := range l.Modules {
+		if l.Modules[i].Name == name {
+			return &l.Modules[i]
+		}
+	}
+	return nil
+}
+
+// pinned returns the lock entry for mod, or nil if the module has no entry
+// or its repo or revision changed since it was locked.
+func (l *Lock) pinned(mod Module) *LockedModule {
+	locked := l.Find(mod.Name)
+	if locked == nil || locked.Repo != mod.Repo || locked.Revision != mod.Revision {
+		return nil
+	}
+	return locked
+}
+
+// verifyFrozen checks that every module in cfg is locked with the same repo, revision and paths,
+// and that the lock has no entries for modules that are not in cfg.
+func (l *Lock) verifyFrozen(cfg *Config) error {
+	var errs []error
+	for _, mod := range cfg.Modules {
+		locked := l.Find(mod.Name)
+		switch {
+		case locked == nil:
+			errs = append(errs, fmt.Errorf("module %s is not locked", mod.Name))
+		case locked.Repo != mod.Repo:
+			errs = append(errs, fmt.Errorf("module %s: repo %q does not match locked repo %q", mod.Name, mod.Repo, locked.Repo))
+		case locked.Revision != mod.Revision:
+			errs = append(errs, fmt.Errorf("module %s: revision %q does not match locked revision %q", mod.Name, mod.Revision, locked.Revision))
+		case !pathsMatch(mod.Paths, locked.Paths):
+			errs = append(errs, fmt.Errorf("module %s: paths do not match locked paths", mod.Name))
+		}
+	}
+	for _, locked := range l.Modules {
+		if !slices.ContainsFunc(cfg.Modules, func(mod Module) bool { return mod.Name == locked.Name }) {
+			errs = append(errs, fmt.Errorf("lock has an entry for unknown module %s", locked.Name))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("lock file is out of date (run `demod sync` to refresh it):\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func pathsMatch(paths []Path, locked []LockedPath) bool {
+	return slices.EqualFunc(paths, locked, func(p Path, lp LockedPath) bool {
+		return p.Src == lp.Src && p.As == lp.As && slices.Equal(p.Exclude, lp.Exclude) && p.Symlinks == lp.Symlinks
+	})
+}
@@ -0,0 +1,165 @@
+package demod
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), LockFileName)
+	lock := &Lock{
+		Version: 1,
+		Modules: []LockedModule{
+			{
+				Name:     "foo",
+				Repo:     "https://github.com/example/foo",
+				Revision: "main",
+				Commit:   "0123456789abcdef0123456789abcdef01234567",
+				Paths: []LockedPath{
+					{Src: "src/lib", As: "lib", Exclude: []string{"*.md"}, Hash: "sha256:abc"},
+				},
+			},
+		},
+	}
+
+	if err := lock.Save(path); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	got, err := LoadLock(path)
+	if err != nil {
+		t.Fatalf("LoadLock: %v", err)
+	}
+	if len(got.Modules) != 1 {
+		t.Fatalf("len(modules) = %d, want 1", len(got.Modules))
+	}
+	mod := got.Modules[0]
+	if mod.Commit != lock.Modules[0].Commit {
+		t.Errorf("commit = %q, want %q", mod.Commit, lock.Modules[0].Commit)
+	}
+	if mod.Paths[0].As != "lib" {
+		t.Errorf("paths[0].as = %q, want %q", mod.Paths[0].As, "lib")
+	}
+	if mod.Paths[0].Hash != "sha256:abc" {
+		t.Errorf("paths[0].hash = %q, want %q", mod.Paths[0].Hash, "sha256:abc")
+	}
+}
+
+func TestLoadLock(t *testing.T) {
+	t.Run("file not found", func(t *testing.T) {
+		_, err := LoadLock(filepath.Join(t.TempDir(), LockFileName))
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("err = %v, want fs.ErrNotExist", err)
+		}
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), LockFileName)
+		if err := os.WriteFile(path, []byte("version = 2\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadLock(path); err == nil {
+			t.Fatal("expected error for unsupported version")
+		}
+	})
+}
+
+func TestLockPath(t *testing.T) {
+	got := LockPath(filepath.Join("project", "demod.toml"))
+	want := filepath.Join("project", LockFileName)
+	if got != want {
+		t.Errorf("LockPath = %q, want %q", got, want)
+	}
+}
+
+func TestLockPinned(t *testing.T) {
+	lock := &Lock{
+		Version: 1,
+		Modules: []LockedModule{
+			{Name: "foo", Repo: "https://github.com/example/foo", Revision: "main", Commit: "abc"},
+		},
+	}
+	mod := Module{Name: "foo", Repo: "https://github.com/example/foo", Revision: "main"}
+
+	if got := lock.pinned(mod); got == nil || got.Commit != "abc" {
+		t.Errorf("pinned = %+v, want commit %q", got, "abc")
+	}
+
+	changed := mod
+	changed.Revision = "v2"
+	if got := lock.pinned(changed); got != nil {
+		t.Errorf("pinned with changed revision = %+v, want nil", got)
+	}
+
+	other := mod
+	other.Name = "bar"
+	if got := lock.pinned(other); got != nil {
+		t.Errorf("pinned for unlocked module = %+v, want nil", got)
+	}
+}
+
+func TestLockVerifyFrozen(t *testing.T) {
+	newConfig := func() *Config {
+		return &Config{
+			Version: 1,
+			Modules: []Module{{
+				Name:     "foo",
+				Repo:     "https://github.com/example/foo",
+				Revision: "main",
+				Dest:     "vendor/foo",
+				Paths:    []Path{{Src: "src/lib", As: "lib", Exclude: []string{"*.md"}}},
+			}},
+		}
+	}
+	lock := &Lock{
+		Version: 1,
+		Modules: []LockedModule{{
+			Name:     "foo",
+			Repo:     "https://github.com/example/foo",
+			Revision: "main",
+			Commit:   "abc",
+			Paths:    []LockedPath{{Src: "src/lib", As: "lib", Exclude: []string{"*.md"}, Hash: "sha256:abc"}},
+		}},
+	}
+
+	t.Run("up to date", func(t *testing.T) {
+		if err := lock.verifyFrozen(newConfig()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("missing entry", func(t *testing.T) {
+		cfg := newConfig()
+		cfg.Modules[0].Name = "bar"
+		if err := lock.verifyFrozen(cfg); err == nil {
+			t.Fatal("expected error for module without lock entry")
+		}
+	})
+
+	t.Run("repo changed", func(t *testing.T) {
+		cfg := newConfig()
+		cfg.Modules[0].Repo = "https://github.com/example/other"
+		if err := lock.verifyFrozen(cfg); err == nil {
+			t.Fatal("expected error for changed repo")
+		}
+	})
+
+	t.Run("revision changed", func(t *testing.T) {
+		cfg := newConfig()
+		cfg.Modules[0].Revision = "v2"
+		if err := lock.verifyFrozen(cfg); err == nil {
+			t.Fatal("expected error for changed revision")
+		}
+	})
+
+	t.Run("paths changed", func(t *testing.T) {
+		cfg := newConfig()
+		cfg.Modules[0].Paths[0].Exclude = nil
+		if err := lock.verifyFrozen(cfg); err == nil {
+			t.Fatal("expected error for changed paths")
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package demod
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ManifestFileName is the name of the manifest file written into each module dest.
+// It uses the sha256sum format, so its regular files can also be checked with `sha256sum -c`.
+const ManifestFileName = ".demod.sum"
+
+// Manifest maps the slash-separated path of every file written by a sync, relative to the
+// module dest, to the hex-encoded SHA-256 digest of its content (see symlinkDigest for symlinks).
+// The files in the manifest are the files demod owns; anything else in the dest is left alone.
+type Manifest map[string]string
+
+// readManifest reads the manifest in dest.
+// A missing manifest is reported as an error wrapping fs.ErrNotExist.
+func readManifest(dest string) (Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dest, ManifestFileName))
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+
+	m := make(Manifest)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		sum, name, ok := strings.Cut(sc.Text(), "  ")
+		if !ok || len(sum) != sha256.Size*2 || name == "" {
+			return nil, fmt.Errorf("parsing manifest: line %d: malformed entry", n)
+		}
+		if !filepath.IsLocal(filepath.FromSlash(name)) || path.Clean(name) != name {
+			return nil, fmt.Errorf("parsing manifest: line %d: invalid path %q", n, name)
+		}
+		m[name] = sum
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("parsing manifest: %w", err)
+	}
+	return m, nil
+}
+
+// readManifestOrEmpty is like readManifest but returns an empty manifest if dest has none.
+func readManifestOrEmpty(dest string) (Manifest, error) {
+	m, err := readManifest(dest)
+	if errors.Is(err, fs.ErrNotExist) {
+		return make(Manifest), nil
+	}
+	return m, err
+}
+
+// write writes the manifest into dest.
+func (m Manifest) write(dest string) error {
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	var buf bytes.Buffer
+	for _, name := range m.files() {
+		_, _ = fmt.Fprintf(&buf, "%s  %s\n", m[name], name)
+	}
+	if err := os.WriteFile(filepath.Join(dest, ManifestFileName), buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	return nil
+}
+
+// files returns the sorted file paths in the manifest.
+func (m Manifest) files() []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// hashPath returns a digest of the files under destPath, the destination of a single module path.
+// The digest covers each file's path relative to destPath and its content digest,
+// so renaming, adding or removing a file changes the result.
+func (m Manifest) hashPath(destPath string) string {
+	prefix := path.Clean(filepath.ToSlash(destPath)) + "/"
+	if prefix == "./" {
+		prefix = ""
+	}
+
+	h := sha256.New()
+	for _, name := range m.files() {
+		if rel, ok := strings.CutPrefix(name, prefix); ok {
+			_, _ = fmt.Fprintf(h, "%s  %s\n", m[name], rel)
+		}
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// fileDigest returns the manifest digest of the file at path without following symlinks.
+func fileDigest(path string) (string, error) {
+	link, err := readSymlink(path)
+	if err != nil {
+		return "", err
+	}
+	if link != "" {
+		return symlinkDigest(link), nil
+	}
+	return hashFile(path)
+}
+
+// symlinkDigest returns the manifest digest of a symlink pointing to link.
+func symlinkDigest(link string) string {
+	sum := sha256.Sum256([]byte("symlink " + link))
+	return hex.EncodeToString(sum[:])
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,131 @@
+package demod
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	sumA = "9834876dcfb05cb167a5c24953eba58c4ac89b1adf57f28f2f9d09af107ee8f0" // sha256("aaa")
+	sumB = "3e744b9dc39389baf0c5a0660589b8402f3dbb49b89b3e75f2c9355852a3c677" // sha256("bbb")
+)
+
+func TestManifestWriteRead(t *testing.T) {
+	dir := t.TempDir()
+	m := Manifest{"lib/a.txt": sumA, "docs/b.txt": sumB}
+
+	if err := m.write(dir); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sumB + "  docs/b.txt\n" + sumA + "  lib/a.txt\n"
+	if string(data) != want {
+		t.Errorf("manifest = %q, want %q", data, want)
+	}
+
+	got, err := readManifest(dir)
+	if err != nil {
+		t.Fatalf("readManifest: %v", err)
+	}
+	if len(got) != 2 || got["lib/a.txt"] != sumA || got["docs/b.txt"] != sumB {
+		t.Errorf("manifest = %v, want %v", got, m)
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	t.Run("not found", func(t *testing.T) {
+		_, err := readManifest(t.TempDir())
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("err = %v, want fs.ErrNotExist", err)
+		}
+	})
+
+	t.Run("path outside dest", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, ManifestFileName), []byte(sumA+"  ../escape.txt\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readManifest(dir); err == nil {
+			t.Fatal("expected error for path outside dest")
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, ManifestFileName), []byte("garbage\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readManifest(dir); err == nil {
+			t.Fatal("expected error for malformed manifest")
+		}
+	})
+}
+
+func TestManifestHashPath(t *testing.T) {
+	m := Manifest{"lib/a.txt": sumA, "docs/b.txt": sumB}
+
+	lib := m.hashPath("lib")
+	if !strings.HasPrefix(lib, "sha256:") {
+		t.Errorf("hash = %q, want sha256: prefix", lib)
+	}
+	if lib == m.hashPath("docs") {
+		t.Error("different paths produced the same hash")
+	}
+
+	// Only files under the path contribute to its hash.
+	m["docs/c.txt"] = sumA
+	if got := m.hashPath("lib"); got != lib {
+		t.Errorf("hash changed after adding a file outside the path: %q vs %q", got, lib)
+	}
+
+	m["lib/a.txt"] = sumB
+	if got := m.hashPath("lib"); got == lib {
+		t.Error("hash did not change after modifying content")
+	}
+}
+
+func TestHashFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(path, []byte("aaa"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := hashFile(path)
+	if err != nil {
+		t.Fatalf("hashFile: %v", err)
+	}
+	if got != sumA {
+		t.Errorf("hash = %q, want %q", got, sumA)
+	}
+}
+
+func TestFileDigest(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "aaa"})
+	if err := os.Symlink("a.txt", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := fileDigest(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatalf("fileDigest: %v", err)
+	}
+	if got != sumA {
+		t.Errorf("file digest = %q, want %q", got, sumA)
+	}
+
+	got, err = fileDigest(filepath.Join(dir, "link"))
+	if err != nil {
+		t.Fatalf("fileDigest: %v", err)
+	}
+	if got != symlinkDigest("a.txt") {
+		t.Errorf("symlink digest = %q, want %q", got, symlinkDigest("a.txt"))
+	}
+}
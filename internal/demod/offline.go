@@ -0,0 +1,75 @@
+package demod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// ErrNotCached is returned in offline mode when a repository, revision or file content a module
+// needs is not in the cache.
+var ErrNotCached = errors.New("not in cache")
+
+// offlineEnv makes git refuse every transport, so no command can reach a remote, including the
+// lazy fetches of missing blobs from the partial clone mirror. Its value names no real protocol.
+const offlineEnv = "GIT_ALLOW_PROTOCOL=demod-offline"
+
+// openCachedMirror is like openMirror but fails with ErrNotCached if repo has no mirror in the cache at dir.
+func openCachedMirror(ctx context.Context, logger *slog.Logger, dir, repo string) (string, func(), error) {
+	if _, err := os.Stat(mirrorPath(dir, repo)); errors.Is(err, fs.ErrNotExist) {
+		return "", nil, fmt.Errorf("repository %s: %w", repo, ErrNotCached)
+	} else if err != nil {
+		return "", nil, err
+	}
+	return openMirror(ctx, logger, dir, repo)
+}
+
+// resolveCached resolves revision like fetchRevision, but only against the refs and commits
+// already in mirror. Branches resolve to the commit they were at when last fetched.
+func resolveCached(ctx context.Context, logger *slog.Logger, mirror, revision string) (commit, tag string, err error) {
+	refs, err := gitLocalRefs(ctx, logger, mirror)
+	if err != nil {
+		return "", "", err
+	}
+	if isConstraint(revision) {
+		c, err := parseConstraint(revision)
+		if err != nil {
+			return "", "", err
+		}
+		var ok bool
+		if tag, ok = bestTag(refs, c); !ok {
+			return "", "", fmt.Errorf("revision %s: no matching tag: %w", revision, ErrNotCached)
+		}
+		commit = refs["refs/tags/"+tag]
+	} else if ref, ok := matchRef(refs, revision); ok {
+		commit = refs[ref]
+		if name, ok := strings.CutPrefix(ref, "refs/tags/"); ok {
+			tag = name
+		}
+	} else if commit, err = gitRevParse(ctx, logger, mirror, revision); err != nil {
+		return "", "", fmt.Errorf("revision %s: %w", revision, ErrNotCached)
+	}
+	if err := checkCached(ctx, logger, mirror, commit); err != nil {
+		return "", "", err
+	}
+	logger.Debug("resolved from cache", "revision", revision, "commit", commit)
+	return commit, tag, nil
+}
+
+// checkCached returns ErrNotCached if commit is not in mirror.
+func checkCached(ctx context.Context, logger *slog.Logger, mirror, commit string) error {
+	if !gitHasCommit(ctx, logger, mirror, commit) {
+		return fmt.Errorf("commit %s: %w", commit, ErrNotCached)
+	}
+	return nil
+}
+
+// notCachedContent reports whether err is a checkout failing because file content is missing
+// from the mirror and could not be fetched in offline mode.
+func notCachedContent(err error) bool {
+	return strings.Contains(err.Error(), "from promisor remote")
+}
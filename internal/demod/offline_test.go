@@ -0,0 +1,96 @@
+package demod
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncAll_Offline(t *testing.T) {
+	bare := setupBareRepo(t)
+	gitTestOutput(t, bare, "tag", "v1.0.0", "main")
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "cache")
+	module := func(name, revision string) Module {
+		return Module{Name: name, Repo: bare, Revision: revision, Dest: filepath.Join(dir, name), Paths: []Path{{Src: "src/lib"}}}
+	}
+	cfg := &Config{Version: 1, Modules: []Module{module("branch", "main"), module("constraint", "^1.0")}}
+	if err := SyncAll(t.Context(), cfg, SyncOptions{CacheDir: cacheDir}); err != nil {
+		t.Fatalf("SyncAll: %v", err)
+	}
+	pushCommit(t, bare, map[string]string{"src/lib/a.txt": "changed"})
+
+	t.Run("resolves from cached refs", func(t *testing.T) {
+		for _, mod := range cfg.Modules {
+			if err := os.RemoveAll(mod.Dest); err != nil {
+				t.Fatal(err)
+			}
+		}
+		lockFile := filepath.Join(t.TempDir(), LockFileName)
+		if err := SyncAll(t.Context(), cfg, SyncOptions{CacheDir: cacheDir, LockFile: lockFile, Offline: true}); err != nil {
+			t.Fatalf("SyncAll offline: %v", err)
+		}
+		// The branch resolves to the commit it was at when last fetched, not the new upstream commit.
+		assertFileContent(t, filepath.Join(dir, "branch", "src", "lib", "a.txt"), "aaa")
+		assertFileContent(t, filepath.Join(dir, "constraint", "src", "lib", "a.txt"), "aaa")
+
+		lock, err := LoadLock(lockFile)
+		if err != nil {
+			t.Fatalf("LoadLock: %v", err)
+		}
+		if got := lock.Find("constraint").Tag; got != "v1.0.0" {
+			t.Errorf("tag = %q, want %q", got, "v1.0.0")
+		}
+	})
+
+	t.Run("lists every module missing from the cache", func(t *testing.T) {
+		missing := &Config{Version: 1, Modules: []Module{
+			module("branch", "main"),
+			module("revision", "no-such-branch"),
+			{Name: "repo", Repo: filepath.Join(dir, "other.git"), Revision: "main", Dest: filepath.Join(dir, "repo"), Paths: []Path{{Src: "src"}}},
+		}}
+		lockFile := filepath.Join(t.TempDir(), LockFileName)
+		err := SyncAll(t.Context(), missing, SyncOptions{CacheDir: cacheDir, LockFile: lockFile, Offline: true})
+		var failed ModuleErrors
+		if !errors.As(err, &failed) {
+			t.Fatalf("err = %v, want ModuleErrors", err)
+		}
+		if len(failed) != 2 || failed[0].Module != "revision" || failed[1].Module != "repo" {
+			t.Fatalf("failed = %v, want revision and repo", failed)
+		}
+		for _, e := range failed {
+			if !errors.Is(e, ErrNotCached) {
+				t.Errorf("[%s] err = %v, want ErrNotCached", e.Module, e.Err)
+			}
+		}
+		if _, err := os.Stat(mirrorPath(cacheDir, filepath.Join(dir, "other.git"))); !os.IsNotExist(err) {
+			t.Errorf("expected no mirror to be created offline, got err: %v", err)
+		}
+	})
+
+	t.Run("locked commit missing from the cache", func(t *testing.T) {
+		lockFile := filepath.Join(t.TempDir(), LockFileName)
+		locked := module("branch", "main")
+		lock := &Lock{Version: 1, Modules: []LockedModule{{
+			Name:     locked.Name,
+			Repo:     locked.Repo,
+			Revision: locked.Revision,
+			Commit:   gitTestOutput(t, bare, "rev-parse", "main"),
+			Paths:    []LockedPath{{Src: "src/lib"}},
+		}}}
+		if err := lock.Save(lockFile); err != nil {
+			t.Fatal(err)
+		}
+		err := SyncAll(t.Context(), &Config{Version: 1, Modules: []Module{locked}}, SyncOptions{CacheDir: cacheDir, LockFile: lockFile, Offline: true})
+		if !errors.Is(err, ErrNotCached) {
+			t.Fatalf("err = %v, want ErrNotCached", err)
+		}
+	})
+
+	t.Run("requires a cache dir", func(t *testing.T) {
+		if err := SyncAll(t.Context(), cfg, SyncOptions{Offline: true}); err == nil {
+			t.Fatal("expected error without a cache dir")
+		}
+	})
+}
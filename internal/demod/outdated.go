@@ -0,0 +1,176 @@
+package demod
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// ModuleStatus compares the locked commit of a module with the latest commit of its revision upstream.
+type ModuleStatus struct {
+	Module   string `json:"module"`
+	Revision string `json:"revision"`
+	// Current is the locked commit, or empty if the module is not locked.
+	Current string `json:"current"`
+	// Latest is the commit the revision resolves to upstream. Commit ID revisions never move.
+	Latest string `json:"latest"`
+	// LatestTag is the newest release tag for semver constraint and release tag revisions.
+	LatestTag string `json:"latest_tag,omitempty"`
+	// Behind is the number of commits reachable from Latest but not from Current,
+	// or -1 if the module is not locked.
+	Behind int `json:"behind"`
+}
+
+// Outdated reports for every module in cfg how far its locked commit is behind upstream:
+// the tip of its branch, or the newest release tag for semver constraint and release tag revisions.
+// It fetches the history of every module repository into the cache, but does not touch the module dests.
+func Outdated(ctx context.Context, cfg *Config, opts SyncOptions) ([]ModuleStatus, error) {
+	lock, err := loadLockOrEmpty(opts.LockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpdir, err := os.MkdirTemp("", "demod-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating temp dir: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpdir) }()
+
+	statuses := make([]ModuleStatus, len(cfg.Modules))
+	g, ctx := errgroup.WithContext(opts.gitContext(ctx))
+	limit := opts.limiter()
+	for i, mod := range cfg.Modules {
+		g.Go(func() error {
+			release, err := limit.acquire(ctx, mod.remotes()[0])
+			if err != nil {
+				return err
+			}
+			defer release()
+
+			var current string
+			if locked := lock.Find(mod.Name); locked != nil && locked.Repo == mod.Repo {
+				current = locked.Commit
+			}
+			ctx, cancel := moduleContext(ctx, mod)
+			defer cancel()
+			ctx, err = opts.authContext(ctx, mod)
+			if err != nil {
+				return fmt.Errorf("[%s] %w", mod.Name, err)
+			}
+			status, err := moduleStatus(ctx, WithModule(opts.logger(), mod.Name), mod, current, opts.cacheDir(tmpdir))
+			if err != nil {
+				return fmt.Errorf("[%s] %w", mod.Name, err)
+			}
+			statuses[i] = *status
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+func moduleStatus(ctx context.Context, logger *slog.Logger, mod Module, current, cacheDir string) (*ModuleStatus, error) {
+	mirror, unlock, err := openMirror(ctx, logger, cacheDir, mod.Repo)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var status *ModuleStatus
+	err = withRemotes(ctx, logger, mirror, mod.remotes(), func() (err error) {
+		status, err = mirrorStatus(ctx, logger, mirror, mod, current)
+		return err
+	})
+	return status, err
+}
+
+// mirrorStatus is moduleStatus fetching from the current origin of mirror.
+func mirrorStatus(ctx context.Context, logger *slog.Logger, mirror string, mod Module, current string) (*ModuleStatus, error) {
+	status := &ModuleStatus{Module: mod.Name, Revision: mod.Revision, Current: current, Behind: -1}
+
+	logger.Info("checking upstream", "revision", mod.Revision)
+	refs, err := gitLsRemote(ctx, logger, mirror)
+	if err != nil {
+		return nil, err
+	}
+	ref, tag, err := latestRef(refs, mod.Revision)
+	if err != nil {
+		return nil, err
+	}
+	if ref == "" {
+		// A commit ID (or another revision that is not a ref) does not move upstream.
+		status.Latest = current
+		if current == "" {
+			commit, _, err := fetchRevision(ctx, logger, mirror, mod.Revision)
+			if err != nil {
+				return nil, err
+			}
+			status.Latest = commit
+		}
+	} else {
+		status.Latest, status.LatestTag = refs[ref], tag
+	}
+	if current == "" {
+		return status, nil
+	}
+	if current == status.Latest {
+		status.Behind = 0
+		return status, nil
+	}
+
+	// Counting the commits in between needs the history of both commits.
+	if err := gitFetchAll(ctx, logger, mirror); err != nil {
+		return nil, err
+	}
+	if err := fetchCommit(ctx, logger, mirror, status.Latest, status.Latest); err != nil {
+		return nil, err
+	}
+	if err := fetchCommit(ctx, logger, mirror, current, current); err != nil {
+		return nil, err
+	}
+	out, err := gitOutput(ctx, logger, mirror, "rev-list", "--count", current+".."+status.Latest)
+	if err != nil {
+		return nil, err
+	}
+	status.Behind, err = strconv.Atoi(strings.TrimSpace(out))
+	if err != nil {
+		return nil, fmt.Errorf("counting commits: %w", err)
+	}
+	return status, nil
+}
+
+// latestRef returns the ref in refs that holds the latest commit for revision, and the tag name
+// if it is a release tag. Semver constraints resolve to the newest matching release tag and
+// release tags to the newest release tag. It returns "" for revisions that are not refs.
+func latestRef(refs map[string]string, revision string) (ref, tag string, err error) {
+	if isConstraint(revision) {
+		c, err := parseConstraint(revision)
+		if err != nil {
+			return "", "", err
+		}
+		tag, ok := bestTag(refs, c)
+		if !ok {
+			return "", "", fmt.Errorf("no tag matches %s", revision)
+		}
+		return "refs/tags/" + tag, tag, nil
+	}
+
+	ref, ok := matchRef(refs, revision)
+	if !ok {
+		return "", "", nil
+	}
+	name, isTag := strings.CutPrefix(ref, "refs/tags/")
+	if v, ok := parseSemver(name); isTag && ok && v.pre == "" {
+		tag, _ := bestTag(refs, semverConstraint{min: v, max: semver{major: math.MaxInt}})
+		return "refs/tags/" + tag, tag, nil
+	}
+	return ref, "", nil
+}
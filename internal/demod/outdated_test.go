@@ -0,0 +1,88 @@
+package demod
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestOutdated(t *testing.T) {
+	bare := setupBareRepo(t)
+	first := gitTestOutput(t, bare, "rev-parse", "main")
+	gitTestOutput(t, bare, "tag", "v1.0.0", first)
+	dir := t.TempDir()
+	lockFile := filepath.Join(dir, LockFileName)
+	cfg := &Config{
+		Version: 1,
+		Modules: []Module{
+			{Name: "branch", Repo: bare, Revision: "main", Dest: filepath.Join(dir, "branch"), Paths: []Path{{Src: "src/lib"}}},
+			{Name: "tag", Repo: bare, Revision: "v1.0.0", Dest: filepath.Join(dir, "tag"), Paths: []Path{{Src: "src/lib"}}},
+			{Name: "commit", Repo: bare, Revision: first, Dest: filepath.Join(dir, "commit"), Paths: []Path{{Src: "src/lib"}}},
+		},
+	}
+	opts := SyncOptions{LockFile: lockFile, CacheDir: t.TempDir()}
+
+	if err := SyncAll(t.Context(), cfg, opts); err != nil {
+		t.Fatalf("SyncAll: %v", err)
+	}
+	pushCommit(t, bare, map[string]string{"src/lib/a.txt": "aaa2"})
+	second := pushCommit(t, bare, map[string]string{"src/lib/a.txt": "aaa3"})
+	gitTestOutput(t, bare, "tag", "v1.1.0", second)
+	cfg.Modules = append(cfg.Modules, Module{Name: "unlocked", Repo: bare, Revision: "main", Dest: filepath.Join(dir, "unlocked"), Paths: []Path{{Src: "src/lib"}}})
+
+	statuses, err := Outdated(t.Context(), cfg, opts)
+	if err != nil {
+		t.Fatalf("Outdated: %v", err)
+	}
+	want := []ModuleStatus{
+		{Module: "branch", Revision: "main", Current: first, Latest: second, Behind: 2},
+		{Module: "tag", Revision: "v1.0.0", Current: first, Latest: second, LatestTag: "v1.1.0", Behind: 2},
+		{Module: "commit", Revision: first, Current: first, Latest: first, Behind: 0},
+		{Module: "unlocked", Revision: "main", Latest: second, Behind: -1},
+	}
+	if len(statuses) != len(want) {
+		t.Fatalf("len(statuses) = %d, want %d", len(statuses), len(want))
+	}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Errorf("statuses[%d] = %+v, want %+v", i, statuses[i], want[i])
+		}
+	}
+}
+
+func TestLatestRef(t *testing.T) {
+	refs := map[string]string{
+		"HEAD":                  "a",
+		"refs/heads/main":       "a",
+		"refs/tags/v1.2.0":      "b",
+		"refs/tags/v1.3.0":      "c",
+		"refs/tags/v2.0.0":      "d",
+		"refs/tags/v2.1.0-rc.1": "e",
+		"refs/tags/nightly":     "f",
+	}
+
+	tests := []struct {
+		revision string
+		ref, tag string
+	}{
+		{"main", "refs/heads/main", ""},
+		{"HEAD", "HEAD", ""},
+		{"v1.2.0", "refs/tags/v2.0.0", "v2.0.0"},
+		{"^1.2", "refs/tags/v1.3.0", "v1.3.0"},
+		{"nightly", "refs/tags/nightly", ""},
+		{"0123456789012345678901234567890123456789", "", ""},
+	}
+	for _, tt := range tests {
+		ref, tag, err := latestRef(refs, tt.revision)
+		if err != nil {
+			t.Errorf("latestRef(%q): %v", tt.revision, err)
+			continue
+		}
+		if ref != tt.ref || tag != tt.tag {
+			t.Errorf("latestRef(%q) = %q, %q, want %q, %q", tt.revision, ref, tag, tt.ref, tt.tag)
+		}
+	}
+
+	if _, _, err := latestRef(refs, "^3"); err == nil {
+		t.Error("expected error when no tag matches")
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package demod
+
+import "os/exec"
+
+// setProcessGroup is a no-op on platforms without process groups; canceling cmd kills git only.
+func setProcessGroup(cmd *exec.Cmd) {}
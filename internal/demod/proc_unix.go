@@ -0,0 +1,34 @@
+//go:build unix
+
+package demod
+
+import (
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// setProcessGroup runs cmd in its own process group and makes canceling it interrupt the
+// whole group, so the helpers git starts (ssh, remote helpers) stop along with it.
+//
+// A process outside the foreground group that reads the terminal is stopped with SIGTTIN, which
+// would hang the sync on a password, passphrase or host key prompt. So git and ssh are told never
+// to prompt (GIT_TERMINAL_PROMPT=0 and ssh -o BatchMode=yes) and fail instead. The ssh options are
+// added to GIT_SSH_COMMAND, which git prefers over core.sshCommand and GIT_SSH.
+// cmd.Env must be set before calling setProcessGroup.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGINT) }
+	cmd.Env = append(cmd.Env, "GIT_TERMINAL_PROMPT=0", "GIT_SSH_COMMAND="+batchSSHCommand(cmd.Env))
+}
+
+// batchSSHCommand returns the GIT_SSH_COMMAND of env, or plain ssh, with interactive prompts disabled.
+func batchSSHCommand(env []string) string {
+	command := "ssh"
+	for _, e := range env {
+		if v, ok := strings.CutPrefix(e, "GIT_SSH_COMMAND="); ok && v != "" {
+			command = v
+		}
+	}
+	return command + " -o BatchMode=yes"
+}
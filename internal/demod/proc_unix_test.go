@@ -0,0 +1,37 @@
+//go:build unix
+
+package demod
+
+import (
+	"os/exec"
+	"slices"
+	"testing"
+)
+
+func TestSetProcessGroup(t *testing.T) {
+	tests := []struct {
+		env  []string
+		want string
+	}{
+		{nil, "GIT_SSH_COMMAND=ssh -o BatchMode=yes"},
+		{[]string{"GIT_SSH_COMMAND="}, "GIT_SSH_COMMAND=ssh -o BatchMode=yes"},
+		{
+			[]string{"GIT_SSH_COMMAND=ssh -p 2222", "GIT_SSH_COMMAND=ssh -i 'key' -o IdentitiesOnly=yes"},
+			"GIT_SSH_COMMAND=ssh -i 'key' -o IdentitiesOnly=yes -o BatchMode=yes",
+		},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command("git")
+		cmd.Env = slices.Clone(tt.env)
+		setProcessGroup(cmd)
+		if !cmd.SysProcAttr.Setpgid {
+			t.Error("expected git to run in its own process group")
+		}
+		if !slices.Contains(cmd.Env, "GIT_TERMINAL_PROMPT=0") {
+			t.Errorf("env = %v, want GIT_TERMINAL_PROMPT=0", cmd.Env)
+		}
+		if got := cmd.Env[len(cmd.Env)-1]; got != tt.want {
+			t.Errorf("env %v: last entry = %q, want %q", tt.env, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package demod
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Rewrite replaces the From prefix of repository URLs with To, for example to fetch from an
+// internal mirror. If Env is set, the rule only applies when that environment variable is set
+// and not empty, or, for "NAME=value", when it has that value.
+type Rewrite struct {
+	From string `toml:"from"`
+	To   string `toml:"to"`
+	Env  string `toml:"env"`
+}
+
+// active reports whether r applies in the current environment.
+func (r Rewrite) active() bool {
+	if r.Env == "" {
+		return true
+	}
+	if name, value, ok := strings.Cut(r.Env, "="); ok {
+		return os.Getenv(name) == value
+	}
+	return os.Getenv(r.Env) != ""
+}
+
+// rewriteURL applies the active rule of rules with the longest matching From prefix to url.
+func rewriteURL(rules []Rewrite, url string) string {
+	var best *Rewrite
+	for i, r := range rules {
+		if strings.HasPrefix(url, r.From) && r.active() && (best == nil || len(r.From) > len(best.From)) {
+			best = &rules[i]
+		}
+	}
+	if best == nil {
+		return url
+	}
+	return best.To + strings.TrimPrefix(url, best.From)
+}
+
+// remotes returns the URLs to fetch mod from, in the order they are tried.
+func (m Module) remotes() []string {
+	if m.URLs != nil {
+		return m.URLs
+	}
+	return append([]string{m.Repo}, m.Mirrors...)
+}
+
+// withRemotes calls fetch with origin of mirror set to each of urls in turn, until it succeeds.
+// It returns the error of the last URL if all of them fail.
+func withRemotes(ctx context.Context, logger *slog.Logger, mirror string, urls []string, fetch func() error) error {
+	for i, url := range urls {
+		if err := gitRemoteSetURL(ctx, logger, mirror, url); err != nil {
+			return err
+		}
+		err := fetch()
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if i == len(urls)-1 {
+			if len(urls) > 1 {
+				return fmt.Errorf("all %d repository URLs failed, last %s: %w", len(urls), url, err)
+			}
+			return err
+		}
+		logger.Warn("fetch failed, trying next mirror", "url", urls[i+1], "error", firstLine(err.Error()))
+	}
+	return nil
+}
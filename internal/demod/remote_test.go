@@ -0,0 +1,83 @@
+package demod
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestRewriteURL(t *testing.T) {
+	t.Setenv("DEMOD_TEST_CI", "true")
+	t.Setenv("DEMOD_TEST_EMPTY", "")
+	rules := []Rewrite{
+		{From: "https://github.com/", To: "https://mirror.internal/github/"},
+		{From: "https://github.com/example/", To: "https://mirror.internal/example/"},
+		{From: "https://gitlab.com/", To: "https://ci-mirror.internal/gitlab/", Env: "DEMOD_TEST_CI"},
+		{From: "https://bitbucket.org/", To: "https://ci-mirror.internal/bitbucket/", Env: "DEMOD_TEST_CI=false"},
+		{From: "https://codeberg.org/", To: "https://ci-mirror.internal/codeberg/", Env: "DEMOD_TEST_EMPTY"},
+	}
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://github.com/foo/bar.git", "https://mirror.internal/github/foo/bar.git"},
+		{"https://github.com/example/bar.git", "https://mirror.internal/example/bar.git"},
+		{"https://gitlab.com/foo/bar.git", "https://ci-mirror.internal/gitlab/foo/bar.git"},
+		{"https://bitbucket.org/foo/bar.git", "https://bitbucket.org/foo/bar.git"},
+		{"https://codeberg.org/foo/bar.git", "https://codeberg.org/foo/bar.git"},
+		{"git@github.com:foo/bar.git", "git@github.com:foo/bar.git"},
+	}
+	for _, tt := range tests {
+		if got := rewriteURL(rules, tt.url); got != tt.want {
+			t.Errorf("rewriteURL(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestModuleRemotes(t *testing.T) {
+	mod := Module{Repo: "https://github.com/foo/bar.git", Mirrors: []string{"https://mirror.internal/bar.git"}}
+	if got, want := mod.remotes(), []string{mod.Repo, mod.Mirrors[0]}; !slices.Equal(got, want) {
+		t.Errorf("remotes() = %v, want %v", got, want)
+	}
+	mod.URLs = []string{"https://rewritten/bar.git"}
+	if got, want := mod.remotes(), mod.URLs; !slices.Equal(got, want) {
+		t.Errorf("remotes() = %v, want %v", got, want)
+	}
+}
+
+func TestSyncAll_Mirrors(t *testing.T) {
+	bare := setupBareRepo(t)
+	dir := t.TempDir()
+	lockFile := filepath.Join(dir, LockFileName)
+	primary := filepath.Join(dir, "unreachable.git")
+	cfg := &Config{
+		Version: 1,
+		Modules: []Module{{
+			Name:     "test",
+			Repo:     primary,
+			Mirrors:  []string{filepath.Join(dir, "also-unreachable.git"), bare},
+			Revision: "main",
+			Dest:     filepath.Join(dir, "dest"),
+			Paths:    []Path{{Src: "src/lib"}},
+		}},
+	}
+
+	if err := SyncAll(t.Context(), cfg, SyncOptions{LockFile: lockFile}); err != nil {
+		t.Fatalf("SyncAll: %v", err)
+	}
+	assertFileContent(t, filepath.Join(dir, "dest", "src", "lib", "a.txt"), "aaa")
+
+	// The lock file records the configured repo, not the mirror it was fetched from.
+	lock, err := LoadLock(lockFile)
+	if err != nil {
+		t.Fatalf("LoadLock: %v", err)
+	}
+	if got := lock.Find("test").Repo; got != primary {
+		t.Errorf("repo = %q, want %q", got, primary)
+	}
+
+	cfg.Modules[0].Mirrors = cfg.Modules[0].Mirrors[:1]
+	if err := SyncAll(t.Context(), cfg, SyncOptions{}); err == nil {
+		t.Fatal("expected error when every URL fails")
+	}
+}
@@ -0,0 +1,115 @@
+package demod
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// fetchRevision makes revision available in mirror and returns the commit it resolves to.
+// If revision is a tag or a semver constraint, it also returns the tag it resolved to.
+//
+// Branches, tags and HEAD are resolved against the refs advertised by origin and only that
+// commit is fetched. Full commit IDs are fetched directly. Anything else, such as an
+// abbreviated commit ID, needs the full history to be resolved.
+func fetchRevision(ctx context.Context, logger *slog.Logger, mirror, revision string) (commit, tag string, err error) {
+	refs, err := gitLsRemote(ctx, logger, mirror)
+	if err != nil {
+		return "", "", err
+	}
+	if isConstraint(revision) {
+		c, err := parseConstraint(revision)
+		if err != nil {
+			return "", "", err
+		}
+		tag, ok := bestTag(refs, c)
+		if !ok {
+			return "", "", fmt.Errorf("no tag matches %s", revision)
+		}
+		ref := "refs/tags/" + tag
+		return refs[ref], tag, fetchCommit(ctx, logger, mirror, ref, refs[ref])
+	}
+	if ref, ok := matchRef(refs, revision); ok {
+		commit := refs[ref]
+		logger.Debug("resolved ref", "ref", ref, "commit", commit)
+		var tag string
+		if name, ok := strings.CutPrefix(ref, "refs/tags/"); ok {
+			tag = name
+		}
+		return commit, tag, fetchCommit(ctx, logger, mirror, ref, commit)
+	}
+	if isCommitID(revision) {
+		return revision, "", fetchCommit(ctx, logger, mirror, revision, revision)
+	}
+
+	logger.Debug("revision not advertised, fetching full history", "revision", revision)
+	if err := gitFetchAll(ctx, logger, mirror); err != nil {
+		return "", "", err
+	}
+	commit, err = gitRevParse(ctx, logger, mirror, revision)
+	if err != nil {
+		return "", "", fmt.Errorf("revision %q not found: %w", revision, err)
+	}
+	return commit, "", nil
+}
+
+// fetchCommit makes commit available in mirror by fetching src, a ref or commit ID that
+// resolves to it, unless the mirror already has it. Servers that refuse to serve a commit
+// that is not a ref tip get a full fetch instead.
+// A fetched ref is stored under the same name in the mirror, and any other commit under
+// refs/demod/commits, so it survives garbage collection. A ref whose commit is already cached
+// is updated to point to it.
+func fetchCommit(ctx context.Context, logger *slog.Logger, mirror, src, commit string) error {
+	dst := "refs/demod/commits/" + commit
+	if strings.HasPrefix(src, "refs/") {
+		dst = src
+	}
+	if gitHasCommit(ctx, logger, mirror, commit) {
+		logger.Debug("commit cached", "commit", commit)
+		// Record where the ref points now, so it can be resolved offline. Annotated tags that
+		// already point to commit are kept as they are, for signature verification.
+		if dst == src {
+			if current, err := gitRevParse(ctx, logger, mirror, dst); err != nil || current != commit {
+				return gitUpdateRef(ctx, logger, mirror, dst, commit)
+			}
+		}
+		return nil
+	}
+	err := gitFetchShallow(ctx, logger, mirror, src, dst)
+	if err == nil && gitHasCommit(ctx, logger, mirror, commit) {
+		return nil
+	}
+	logger.Debug("shallow fetch failed, fetching full history", "commit", commit, "err", err)
+	if err := gitFetchAll(ctx, logger, mirror); err != nil {
+		return err
+	}
+	if !gitHasCommit(ctx, logger, mirror, commit) {
+		return fmt.Errorf("commit %s not found in %s", commit, src)
+	}
+	return nil
+}
+
+// matchRef returns the ref in refs that revision names, trying it as a full ref name,
+// a tag and a branch in that order, as git does.
+func matchRef(refs map[string]string, revision string) (string, bool) {
+	for _, ref := range []string{revision, "refs/tags/" + revision, "refs/heads/" + revision} {
+		if _, ok := refs[ref]; ok {
+			return ref, true
+		}
+	}
+	return "", false
+}
+
+// isCommitID reports whether s is a full SHA-1 or SHA-256 commit ID.
+func isCommitID(s string) bool {
+	if len(s) != 40 && len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,170 @@
+package demod
+
+import (
+	"log/slog"
+	"testing"
+)
+
+func TestFetchRevision(t *testing.T) {
+	logger := slog.Default()
+	bare := setupBareRepo(t)
+	first := gitTestOutput(t, bare, "rev-parse", "main")
+	gitTestOutput(t, bare, "-c", "user.email=test@test.com", "-c", "user.name=Test", "tag", "-a", "-m", "v1", "v1", first)
+	gitTestOutput(t, bare, "tag", "light", first)
+	gitTestOutput(t, bare, "branch", "feature", first)
+	second := pushCommit(t, bare, map[string]string{"src/lib/a.txt": "aaa2"})
+
+	tests := []struct {
+		name     string
+		revision string
+		want     string
+	}{
+		{"default branch", "main", second},
+		{"HEAD", "HEAD", second},
+		{"non-default branch", "feature", first},
+		{"annotated tag", "v1", first},
+		{"lightweight tag", "light", first},
+		{"full ref", "refs/tags/v1", first},
+		{"older commit", first, first},
+		{"abbreviated commit", first[:10], first},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mirror := setupMirror(t, bare)
+			commit, _, err := fetchRevision(t.Context(), logger, mirror, tt.revision)
+			if err != nil {
+				t.Fatalf("fetchRevision: %v", err)
+			}
+			if commit != tt.want {
+				t.Errorf("commit = %q, want %q", commit, tt.want)
+			}
+			if !gitHasCommit(t.Context(), logger, mirror, commit) {
+				t.Errorf("expected %s to be fetched into the mirror", commit)
+			}
+		})
+	}
+
+	t.Run("unknown revision", func(t *testing.T) {
+		mirror := setupMirror(t, bare)
+		if _, _, err := fetchRevision(t.Context(), logger, mirror, "nonexistent"); err == nil {
+			t.Fatal("expected error for unknown revision")
+		}
+	})
+}
+
+func TestFetchRevisionConstraint(t *testing.T) {
+	logger := slog.Default()
+	bare := setupBareRepo(t)
+	commits := make(map[string]string)
+	for i, tag := range []string{"v1.2.0", "v1.4.0", "v1.4.3", "v1.5.0-rc.1", "v2.0.0"} {
+		commits[tag] = pushCommit(t, bare, map[string]string{"src/lib/a.txt": tag})
+		if i%2 == 0 {
+			gitTestOutput(t, bare, "tag", tag, commits[tag])
+		} else {
+			gitTestOutput(t, bare, "-c", "user.email=test@test.com", "-c", "user.name=Test", "tag", "-a", "-m", tag, tag, commits[tag])
+		}
+	}
+
+	tests := []struct {
+		revision string
+		tag      string
+	}{
+		{"^1.4", "v1.4.3"},
+		{"^1", "v1.4.3"},
+		{"~1.2.0", "v1.2.0"},
+		{"~1.4", "v1.4.3"},
+		{"^2.0.0", "v2.0.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.revision, func(t *testing.T) {
+			mirror := setupMirror(t, bare)
+			commit, tag, err := fetchRevision(t.Context(), logger, mirror, tt.revision)
+			if err != nil {
+				t.Fatalf("fetchRevision: %v", err)
+			}
+			if tag != tt.tag {
+				t.Errorf("tag = %q, want %q", tag, tt.tag)
+			}
+			if commit != commits[tt.tag] {
+				t.Errorf("commit = %q, want %q", commit, commits[tt.tag])
+			}
+			if !gitHasCommit(t.Context(), logger, mirror, commit) {
+				t.Errorf("expected %s to be fetched into the mirror", commit)
+			}
+		})
+	}
+
+	t.Run("no matching tag", func(t *testing.T) {
+		mirror := setupMirror(t, bare)
+		if _, _, err := fetchRevision(t.Context(), logger, mirror, "^3"); err == nil {
+			t.Fatal("expected error when no tag matches")
+		}
+	})
+}
+
+func TestFetchCommit(t *testing.T) {
+	logger := slog.Default()
+	bare := setupBareRepo(t)
+	first := gitTestOutput(t, bare, "rev-parse", "main")
+	pushCommit(t, bare, map[string]string{"src/lib/a.txt": "aaa2"})
+
+	t.Run("fetches by commit ID", func(t *testing.T) {
+		mirror := setupMirror(t, bare)
+		if err := fetchCommit(t.Context(), logger, mirror, first, first); err != nil {
+			t.Fatalf("fetchCommit: %v", err)
+		}
+		if got := gitTestOutput(t, mirror, "rev-parse", "refs/demod/commits/"+first); got != first {
+			t.Errorf("pinned ref = %q, want %q", got, first)
+		}
+	})
+
+	t.Run("falls back when the server refuses unadvertised commits", func(t *testing.T) {
+		mirror := setupMirror(t, bare)
+		// Protocol v0 servers only serve advertised ref tips by default.
+		gitTestOutput(t, mirror, "config", "protocol.version", "0")
+		if err := fetchCommit(t.Context(), logger, mirror, first, first); err != nil {
+			t.Fatalf("fetchCommit: %v", err)
+		}
+		if !gitHasCommit(t.Context(), logger, mirror, first) {
+			t.Errorf("expected %s to be fetched into the mirror", first)
+		}
+	})
+
+	t.Run("skips cached commits", func(t *testing.T) {
+		mirror := setupMirror(t, bare)
+		if err := fetchCommit(t.Context(), logger, mirror, first, first); err != nil {
+			t.Fatalf("fetchCommit: %v", err)
+		}
+		// Any fetch from a missing remote would fail.
+		gitTestOutput(t, mirror, "remote", "set-url", "origin", t.TempDir())
+		if err := fetchCommit(t.Context(), logger, mirror, first, first); err != nil {
+			t.Fatalf("fetchCommit with a cached commit: %v", err)
+		}
+	})
+
+	t.Run("unknown commit", func(t *testing.T) {
+		mirror := setupMirror(t, bare)
+		missing := "0123456789012345678901234567890123456789"
+		if err := fetchCommit(t.Context(), logger, mirror, missing, missing); err == nil {
+			t.Fatal("expected error for unknown commit")
+		}
+	})
+}
+
+func TestIsCommitID(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"0123456789abcdef0123456789abcdef01234567", true},
+		{"0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", true},
+		{"0123456", false},
+		{"0123456789ABCDEF0123456789ABCDEF01234567", false},
+		{"main", false},
+	}
+	for _, tt := range tests {
+		if got := isCommitID(tt.s); got != tt.want {
+			t.Errorf("isCommitID(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
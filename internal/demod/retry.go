@@ -0,0 +1,137 @@
+package demod
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Defaults for RetryPolicy fields left at zero.
+const (
+	DefaultRetryAttempts     = 3
+	DefaultRetryInitialDelay = time.Second
+	DefaultRetryMaxDelay     = 30 * time.Second
+)
+
+// RetryPolicy controls how git commands that talk to a remote are retried after a transient
+// failure such as a timeout, a dropped connection or an HTTP 5xx response.
+// Permanent failures (authentication errors, unknown revisions) are never retried.
+type RetryPolicy struct {
+	// Attempts is the total number of tries, including the first. 1 disables retries.
+	Attempts int `toml:"attempts"`
+	// InitialDelay is the delay before the first retry. It doubles after every retry.
+	InitialDelay time.Duration `toml:"initial_delay"`
+	// MaxDelay caps the delay between retries.
+	MaxDelay time.Duration `toml:"max_delay"`
+}
+
+// withDefaults returns p with its zero fields set to the defaults.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.Attempts == 0 {
+		p.Attempts = DefaultRetryAttempts
+	}
+	if p.InitialDelay == 0 {
+		p.InitialDelay = DefaultRetryInitialDelay
+	}
+	if p.MaxDelay == 0 {
+		p.MaxDelay = DefaultRetryMaxDelay
+	}
+	return p
+}
+
+// delay returns how long to wait before retry n, counting from 1.
+func (p RetryPolicy) delay(n int) time.Duration {
+	d := p.InitialDelay
+	for i := 1; i < n && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	return min(d, p.MaxDelay)
+}
+
+// do calls run until it succeeds, fails permanently, or the attempts are used up,
+// waiting between attempts as configured. It returns the last error.
+func (p RetryPolicy) do(ctx context.Context, logger *slog.Logger, run func() error) error {
+	p = p.withDefaults()
+	for attempt := 1; ; attempt++ {
+		err := run()
+		if err == nil || ctx.Err() != nil || attempt >= p.Attempts || !isTransient(err) {
+			return err
+		}
+		delay := p.delay(attempt)
+		logger.Warn("retrying after transient error", "attempt", attempt+1, "of", p.Attempts, "delay", delay, "error", firstLine(err.Error()))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return fmt.Errorf("%w; last error: %w", context.Cause(ctx), err)
+		}
+	}
+}
+
+// transientErrors match the git output of failures that are worth retrying.
+var transientErrors = regexp.MustCompile(`(?i)` + strings.Join([]string{
+	`could not resolve host`,
+	`temporary failure in name resolution`,
+	`connection (reset|refused|closed)`,
+	`timed out`,
+	`the remote end hung up unexpectedly`,
+	`early eof`,
+	`unexpected disconnect`,
+	`rpc failed`,
+	`transfer closed`,
+	`gnutls_handshake`,
+	`ssl_(read|connect|error)`,
+	`returned error: 5\d\d`,
+	`http 5\d\d`,
+	`(500|502|503|504) (internal server error|bad gateway|service unavailable|gateway time-?out)`,
+}, "|"))
+
+// permanentErrors match the git output of failures that retrying cannot fix, even if the
+// output also matches transientErrors (git often adds "the remote end hung up" to them).
+var permanentErrors = regexp.MustCompile(`(?i)` + strings.Join([]string{
+	`authentication failed`,
+	`permission denied`,
+	`could not read username`,
+	`repository not found`,
+	`does not appear to be a git repository`,
+	`returned error: 4\d\d`,
+	`couldn't find remote ref`,
+	`not our ref`,
+	`unknown revision`,
+	`invalid refspec`,
+}, "|"))
+
+// isTransient reports whether err, a failed git command including its output, looks like
+// a temporary network or server problem.
+func isTransient(err error) bool {
+	msg := err.Error()
+	return transientErrors.MatchString(msg) && !permanentErrors.MatchString(msg)
+}
+
+// networkCommands are the git commands that may talk to a remote: fetches, and checkouts
+// that lazily fetch the blobs missing from the partial clone mirror.
+var networkCommands = map[string]bool{
+	"fetch":     true,
+	"ls-remote": true,
+	"checkout":  true,
+}
+
+type retryKey struct{}
+
+// withRetry returns a context in which the git commands that talk to a remote are retried according to p.
+func withRetry(ctx context.Context, p RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryKey{}, p)
+}
+
+// retryPolicy returns the retry policy set by withRetry, or the default policy.
+func retryPolicy(ctx context.Context) RetryPolicy {
+	p, _ := ctx.Value(retryKey{}).(RetryPolicy)
+	return p
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
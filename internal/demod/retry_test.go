@@ -0,0 +1,98 @@
+package demod
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := RetryPolicy{InitialDelay: time.Second, MaxDelay: 5 * time.Second}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := p.delay(i + 1); got != w {
+			t.Errorf("delay(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want bool
+	}{
+		{"git fetch: exit status 128\nfatal: unable to access 'https://example.com/foo.git/': Could not resolve host: example.com", true},
+		{"git fetch: exit status 128\nerror: RPC failed; curl 56 Recv failure: Connection reset by peer\nfatal: early EOF", true},
+		{"git ls-remote: exit status 128\nfatal: unable to access 'https://example.com/foo.git/': The requested URL returned error: 503", true},
+		{"git fetch: exit status 128\nssh: connect to host example.com port 22: Connection timed out\nfatal: Could not read from remote repository.", true},
+		{"git ls-remote: exit status 128\nfatal: Authentication failed for 'https://example.com/foo.git/'", false},
+		{"git ls-remote: exit status 128\nfatal: unable to access 'https://example.com/foo.git/': The requested URL returned error: 404", false},
+		{"git fetch: exit status 128\ngit@example.com: Permission denied (publickey).\nfatal: the remote end hung up unexpectedly", false},
+		{"git fetch: exit status 128\nfatal: couldn't find remote ref refs/heads/nope", false},
+		{"git checkout: exit status 1\nerror: pathspec 'nope' did not match any file(s) known to git", false},
+	}
+	for _, tt := range tests {
+		if got := isTransient(errors.New(tt.msg)); got != tt.want {
+			t.Errorf("isTransient(%q) = %v, want %v", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	logger := slog.Default()
+	p := RetryPolicy{Attempts: 3, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	transient := errors.New("fatal: the remote end hung up unexpectedly")
+	permanent := errors.New("fatal: couldn't find remote ref refs/heads/nope")
+
+	t.Run("retries transient errors", func(t *testing.T) {
+		calls := 0
+		err := p.do(t.Context(), logger, func() error {
+			calls++
+			if calls < 3 {
+				return transient
+			}
+			return nil
+		})
+		if err != nil || calls != 3 {
+			t.Errorf("err = %v, calls = %d, want nil, 3", err, calls)
+		}
+	})
+
+	t.Run("gives up after the last attempt", func(t *testing.T) {
+		calls := 0
+		err := p.do(t.Context(), logger, func() error {
+			calls++
+			return transient
+		})
+		if !errors.Is(err, transient) || calls != 3 {
+			t.Errorf("err = %v, calls = %d, want transient error, 3", err, calls)
+		}
+	})
+
+	t.Run("does not retry permanent errors", func(t *testing.T) {
+		calls := 0
+		err := p.do(t.Context(), logger, func() error {
+			calls++
+			return permanent
+		})
+		if !errors.Is(err, permanent) || calls != 1 {
+			t.Errorf("err = %v, calls = %d, want permanent error, 1", err, calls)
+		}
+	})
+
+	t.Run("stops waiting when canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		slow := RetryPolicy{Attempts: 3, InitialDelay: time.Hour, MaxDelay: time.Hour}
+		calls := 0
+		err := slow.do(ctx, logger, func() error {
+			calls++
+			cancel()
+			return transient
+		})
+		if !errors.Is(err, transient) || calls != 1 {
+			t.Errorf("err = %v, calls = %d, want transient error, 1", err, calls)
+		}
+	})
+}
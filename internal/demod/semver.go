@@ -0,0 +1,123 @@
+package demod
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// semver is a semantic version parsed from a tag such as v1.4.2 or 2.0.0-rc.1.
+type semver struct {
+	major, minor, patch int
+	pre                 string
+}
+
+// parseSemver parses a MAJOR.MINOR.PATCH version with an optional "v" prefix,
+// pre-release and build metadata.
+func parseSemver(s string) (semver, bool) {
+	s, _, _ = strings.Cut(strings.TrimPrefix(s, "v"), "+")
+	s, pre, _ := strings.Cut(s, "-")
+	nums, ok := parseVersionNumbers(s)
+	if !ok || len(nums) != 3 {
+		return semver{}, false
+	}
+	return semver{major: nums[0], minor: nums[1], patch: nums[2], pre: pre}, true
+}
+
+// parseVersionNumbers parses the dot-separated numbers of a version such as 1, 1.4 or 1.4.2.
+func parseVersionNumbers(s string) ([]int, bool) {
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return nil, false
+	}
+	nums := make([]int, len(parts))
+	for i, part := range parts {
+		if part == "" || len(part) > 1 && part[0] == '0' || strings.TrimLeft(part, "0123456789") != "" {
+			return nil, false
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, false
+		}
+		nums[i] = n
+	}
+	return nums, true
+}
+
+// compare compares the release versions of v and w, ignoring pre-releases.
+func (v semver) compare(w semver) int {
+	switch {
+	case v.major != w.major:
+		return v.major - w.major
+	case v.minor != w.minor:
+		return v.minor - w.minor
+	default:
+		return v.patch - w.patch
+	}
+}
+
+// semverConstraint matches the release versions v with min <= v < max.
+type semverConstraint struct {
+	min, max semver
+}
+
+// isConstraint reports whether revision is a semver constraint rather than a git revision.
+func isConstraint(revision string) bool {
+	return strings.HasPrefix(revision, "^") || strings.HasPrefix(revision, "~")
+}
+
+// parseConstraint parses a caret or tilde constraint with the same meaning as in npm and Cargo:
+// ^1.4 matches >=1.4.0 <2.0.0 (^0.3 matches >=0.3.0 <0.4.0), and ~2.3.0 matches >=2.3.0 <2.4.0.
+func parseConstraint(s string) (semverConstraint, error) {
+	if !isConstraint(s) {
+		return semverConstraint{}, fmt.Errorf("invalid version constraint %q", s)
+	}
+	op := s[:1]
+	nums, ok := parseVersionNumbers(strings.TrimPrefix(s[1:], "v"))
+	if !ok {
+		return semverConstraint{}, fmt.Errorf("invalid version constraint %q", s)
+	}
+	var v [3]int
+	copy(v[:], nums)
+
+	c := semverConstraint{min: semver{major: v[0], minor: v[1], patch: v[2]}}
+	switch {
+	case len(nums) == 1:
+		c.max = semver{major: v[0] + 1}
+	case op == "~":
+		c.max = semver{major: v[0], minor: v[1] + 1}
+	case v[0] > 0:
+		c.max = semver{major: v[0] + 1}
+	case len(nums) == 2 || v[1] > 0:
+		c.max = semver{minor: v[1] + 1}
+	default:
+		c.max = semver{patch: v[2] + 1}
+	}
+	return c, nil
+}
+
+// matches reports whether v is a release within the constraint. Pre-releases never match.
+func (c semverConstraint) matches(v semver) bool {
+	return v.pre == "" && v.compare(c.min) >= 0 && v.compare(c.max) < 0
+}
+
+// bestTag returns the name of the highest tag in refs that matches c.
+func bestTag(refs map[string]string, c semverConstraint) (string, bool) {
+	var best string
+	var bestVersion semver
+	for ref := range refs {
+		tag, ok := strings.CutPrefix(ref, "refs/tags/")
+		if !ok {
+			continue
+		}
+		v, ok := parseSemver(tag)
+		if !ok || !c.matches(v) {
+			continue
+		}
+		// Prefer the lexically smallest name when several tags have the same version (1.0.0 and v1.0.0).
+		if best == "" || v.compare(bestVersion) > 0 || v.compare(bestVersion) == 0 && tag < best {
+			best, bestVersion = tag, v
+		}
+	}
+	return best, best != ""
+}
@@ -0,0 +1,99 @@
+package demod
+
+import "testing"
+
+func TestParseSemver(t *testing.T) {
+	tests := []struct {
+		s    string
+		want semver
+		ok   bool
+	}{
+		{"1.4.2", semver{major: 1, minor: 4, patch: 2}, true},
+		{"v1.4.2", semver{major: 1, minor: 4, patch: 2}, true},
+		{"v2.0.0-rc.1", semver{major: 2, pre: "rc.1"}, true},
+		{"v2.0.0+build.5", semver{major: 2}, true},
+		{"v1.4", semver{}, false},
+		{"v01.4.2", semver{}, false},
+		{"v1.4.x", semver{}, false},
+		{"release-1", semver{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseSemver(tt.s)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseSemver(%q) = %+v, %v, want %+v, %v", tt.s, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseConstraint(t *testing.T) {
+	tests := []struct {
+		s        string
+		min, max string
+	}{
+		{"^1.4", "1.4.0", "2.0.0"},
+		{"^1.4.2", "1.4.2", "2.0.0"},
+		{"^v1", "1.0.0", "2.0.0"},
+		{"^0.3", "0.3.0", "0.4.0"},
+		{"^0.3.1", "0.3.1", "0.4.0"},
+		{"^0.0.3", "0.0.3", "0.0.4"},
+		{"^0.0", "0.0.0", "0.1.0"},
+		{"^0", "0.0.0", "1.0.0"},
+		{"~2.3.0", "2.3.0", "2.4.0"},
+		{"~2.3", "2.3.0", "2.4.0"},
+		{"~2", "2.0.0", "3.0.0"},
+		{"~0.0.3", "0.0.3", "0.1.0"},
+	}
+	for _, tt := range tests {
+		c, err := parseConstraint(tt.s)
+		if err != nil {
+			t.Errorf("parseConstraint(%q): %v", tt.s, err)
+			continue
+		}
+		min, _ := parseSemver(tt.min)
+		max, _ := parseSemver(tt.max)
+		if c.min != min || c.max != max {
+			t.Errorf("parseConstraint(%q) = [%+v, %+v), want [%s, %s)", tt.s, c.min, c.max, tt.min, tt.max)
+		}
+	}
+
+	for _, s := range []string{"^", "^1.x", "~1.2.3.4", ">=1.0", "main"} {
+		if _, err := parseConstraint(s); err == nil {
+			t.Errorf("parseConstraint(%q): expected error", s)
+		}
+	}
+}
+
+func TestBestTag(t *testing.T) {
+	refs := map[string]string{
+		"HEAD":                   "a",
+		"refs/heads/v9.9.9":      "a",
+		"refs/tags/v1.3.9":       "b",
+		"refs/tags/v1.4.0":       "c",
+		"refs/tags/v1.10.1":      "d",
+		"refs/tags/1.10.1":       "d",
+		"refs/tags/v1.11.0-rc.1": "e",
+		"refs/tags/v2.0.0":       "f",
+		"refs/tags/nightly":      "g",
+	}
+
+	tests := []struct {
+		constraint string
+		want       string
+		ok         bool
+	}{
+		{"^1.4", "1.10.1", true},
+		{"~1.4", "v1.4.0", true},
+		{"^2", "v2.0.0", true},
+		{"^3", "", false},
+	}
+	for _, tt := range tests {
+		c, err := parseConstraint(tt.constraint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := bestTag(refs, c)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("bestTag(%q) = %q, %v, want %q, %v", tt.constraint, got, ok, tt.want, tt.ok)
+		}
+	}
+}
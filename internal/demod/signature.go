@@ -0,0 +1,77 @@
+package demod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+)
+
+// verifySignature checks that commit, or the annotated tag it was resolved from, carries a valid
+// signature from a signer allowed by policy. The tag is fetched into mirror if it is not there yet,
+// and must still point to commit.
+//
+// git only sees the configured keys: the user's GPG keyring and allowed signers file are never consulted.
+func verifySignature(ctx context.Context, logger *slog.Logger, mirror string, policy *SignaturePolicy, commit, tag string) error {
+	verifyCtx, cleanup, err := policy.gitContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	commitErr := runGit(verifyCtx, logger, mirror, "verify-commit", commit)
+	if commitErr == nil {
+		logger.Info("verified signature", "commit", commit)
+		return nil
+	}
+	if tag == "" {
+		return fmt.Errorf("commit %s is not signed by an allowed signer: %w", commit, commitErr)
+	}
+
+	ref := "refs/tags/" + tag
+	if _, err := gitOutput(ctx, logger, mirror, "rev-parse", "--verify", "--quiet", ref); err != nil {
+		if err := gitFetchShallow(ctx, logger, mirror, ref, ref); err != nil {
+			return err
+		}
+	}
+	tagged, err := gitRevParse(ctx, logger, mirror, ref)
+	if err != nil {
+		return err
+	}
+	if tagged != commit {
+		return fmt.Errorf("tag %s points to %s, not %s", tag, tagged, commit)
+	}
+	tagErr := runGit(verifyCtx, logger, mirror, "verify-tag", ref)
+	if tagErr == nil {
+		logger.Info("verified signature", "tag", tag, "commit", commit)
+		return nil
+	}
+	return fmt.Errorf("neither commit %s nor tag %s is signed by an allowed signer: %w", commit, tag, errors.Join(commitErr, tagErr))
+}
+
+// gitContext returns a context that restricts signature verification by git to the signers
+// allowed by p, and a function that removes the temporary GPG home it refers to.
+func (p *SignaturePolicy) gitContext(ctx context.Context) (_ context.Context, cleanup func(), err error) {
+	allowedSigners := os.DevNull
+	if p.AllowedSigners != "" {
+		allowedSigners = p.AllowedSigners
+	}
+
+	home, err := os.MkdirTemp("", "demod-gnupg-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating GPG home: %w", err)
+	}
+	cleanup = func() { _ = os.RemoveAll(home) }
+	if p.Keyring != "" {
+		out, err := exec.CommandContext(ctx, "gpg", "--batch", "--quiet", "--homedir", home, "--import", p.Keyring).CombinedOutput()
+		if err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("importing keyring %s: %w\n%s", p.Keyring, err, out)
+		}
+	}
+
+	ctx = withGitEnv(ctx, "GNUPGHOME="+home)
+	return withGitConfig(ctx, "gpg.ssh.allowedSignersFile", allowedSigners), cleanup, nil
+}
@@ -0,0 +1,167 @@
+package demod
+
+import (
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifySignature_SSH(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen not available")
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"trusted", "other"} {
+		if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", filepath.Join(dir, name)).CombinedOutput(); err != nil {
+			t.Fatalf("ssh-keygen: %v\n%s", err, out)
+		}
+	}
+	pub, err := os.ReadFile(filepath.Join(dir, "trusted.pub"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed := filepath.Join(dir, "allowed_signers")
+	if err := os.WriteFile(allowed, []byte("test@test.com "+string(pub)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	bare := setupSignedRepo(t, []string{"-c", "gpg.format=ssh"}, filepath.Join(dir, "trusted.pub"), filepath.Join(dir, "other.pub"))
+	testVerifySignature(t, bare, &SignaturePolicy{AllowedSigners: allowed})
+}
+
+func TestVerifySignature_GPG(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not available")
+	}
+	home, err := os.MkdirTemp("", "demod-gnupg-test-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = exec.Command("gpgconf", "--homedir", home, "--kill", "all").Run()
+		_ = os.RemoveAll(home)
+	})
+	t.Setenv("GNUPGHOME", home)
+
+	gpg := func(args ...string) string {
+		t.Helper()
+		out, err := exec.Command("gpg", append([]string{"--batch", "--quiet"}, args...)...).Output()
+		if err != nil {
+			t.Fatalf("gpg %v: %v", args, err)
+		}
+		return string(out)
+	}
+	var keys []string
+	for _, uid := range []string{"Trusted <trusted@test.com>", "Other <other@test.com>"} {
+		gpg("--passphrase", "", "--quick-gen-key", uid, "ed25519", "sign", "never")
+		for _, line := range strings.Split(gpg("--with-colons", "--list-keys", uid), "\n") {
+			if fields := strings.Split(line, ":"); fields[0] == "fpr" {
+				keys = append(keys, fields[9])
+				break
+			}
+		}
+	}
+	keyring := filepath.Join(t.TempDir(), "keyring.asc")
+	if err := os.WriteFile(keyring, []byte(gpg("--armor", "--export", keys[0])), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	bare := setupSignedRepo(t, []string{"-c", "gpg.format=openpgp"}, keys[0], keys[1])
+	testVerifySignature(t, bare, &SignaturePolicy{Keyring: keyring})
+}
+
+// testVerifySignature checks policy against the repo created by setupSignedRepo.
+func testVerifySignature(t *testing.T, bare string, policy *SignaturePolicy) {
+	t.Helper()
+	logger := slog.Default()
+
+	tests := []struct {
+		revision string
+		ok       bool
+	}{
+		{"trusted", true},
+		{"other", false},
+		{"unsigned", false},
+		{"signed-tag", true},
+		{"unsigned-tag", false},
+		{"other-tag", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.revision, func(t *testing.T) {
+			mirror := setupMirror(t, bare)
+			commit, tag, err := fetchRevision(t.Context(), logger, mirror, tt.revision)
+			if err != nil {
+				t.Fatalf("fetchRevision: %v", err)
+			}
+			err = verifySignature(t.Context(), logger, mirror, policy, commit, tag)
+			if tt.ok && err != nil {
+				t.Errorf("verifySignature: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("expected verification to fail")
+			}
+		})
+	}
+
+	t.Run("tag moved", func(t *testing.T) {
+		mirror := setupMirror(t, bare)
+		trusted := gitTestOutput(t, bare, "rev-parse", "trusted")
+		if _, _, err := fetchRevision(t.Context(), logger, mirror, "signed-tag"); err != nil {
+			t.Fatalf("fetchRevision: %v", err)
+		}
+		if err := verifySignature(t.Context(), logger, mirror, &SignaturePolicy{AllowedSigners: os.DevNull}, trusted, "signed-tag"); err == nil {
+			t.Error("expected verification to fail for a tag pointing to another commit")
+		}
+	})
+}
+
+// setupSignedRepo creates a bare repo with the following branches and tags, signing with the
+// given git config and signing keys:
+//
+//	trusted       commit signed with trustedKey
+//	other         commit signed with otherKey
+//	unsigned      unsigned commit
+//	signed-tag    tag signed with trustedKey, pointing to unsigned
+//	unsigned-tag  annotated tag without signature, pointing to unsigned
+//	other-tag     tag signed with otherKey, pointing to unsigned
+func setupSignedRepo(t *testing.T, config []string, trustedKey, otherKey string) string {
+	t.Helper()
+	workdir := filepath.Join(t.TempDir(), "work")
+	if out, err := exec.Command("git", "init", "-b", "main", workdir).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+	git := func(args ...string) {
+		t.Helper()
+		args = append(append([]string{"-c", "user.email=test@test.com", "-c", "user.name=Test"}, config...), args...)
+		cmd := exec.Command("git", args...)
+		cmd.Dir = workdir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	commit := func(branch string, sign ...string) {
+		t.Helper()
+		git("checkout", "-q", "--orphan", branch)
+		if err := os.WriteFile(filepath.Join(workdir, "file.txt"), []byte(branch), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", "-A")
+		git(append([]string{"commit", "-q", "-m", branch}, sign...)...)
+	}
+	commit("trusted", "-S"+trustedKey)
+	commit("other", "-S"+otherKey)
+	commit("unsigned")
+	git("-c", "user.signingkey="+trustedKey, "tag", "-s", "-m", "signed", "signed-tag", "unsigned")
+	git("tag", "-a", "-m", "unsigned", "unsigned-tag", "unsigned")
+	git("-c", "user.signingkey="+otherKey, "tag", "-s", "-m", "other", "other-tag", "unsigned")
+
+	bare := filepath.Join(t.TempDir(), "bare.git")
+	if out, err := exec.Command("git", "clone", "-q", "--bare", workdir, bare).CombinedOutput(); err != nil {
+		t.Fatalf("creating bare repo: %v\n%s", err, out)
+	}
+	return bare
+}
@@ -2,19 +2,43 @@ package demod
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/bmatcuk/doublestar/v4"
-	"golang.org/x/sync/errgroup"
 )
 
 type SyncOptions struct {
 	DryRun bool
+	// LockFile is the path of the lock file. If empty, revisions are not pinned.
+	LockFile string
+	// Frozen requires every module to be locked with its current config and leaves the lock file untouched.
+	Frozen bool
+	// CacheDir is the directory holding persistent mirrors of module repositories.
+	// If empty, every sync fetches into a temporary mirror.
+	CacheDir string
+	// Jobs is the number of modules processed at once. If zero, DefaultJobs is used.
+	Jobs int
+	// JobsPerHost additionally limits the number of modules processed at once per git host, if positive.
+	JobsPerHost int
+	// KeepGoing processes every module even if some fail, instead of stopping at the first failure.
+	// The errors of all failed modules are then returned together as ModuleErrors.
+	KeepGoing bool
+	// Offline syncs from the mirrors in CacheDir without any network access. Revisions that are not
+	// locked resolve to the refs fetched last. Every module is attempted as if KeepGoing was set, and
+	// the modules whose repository, commit or file content is not cached fail with ErrNotCached.
+	Offline bool
+	// Retry controls how git commands that talk to a remote are retried after transient failures.
+	// Zero fields take the defaults.
+	Retry  RetryPolicy
 	Logger *slog.Logger
 }
 
@@ -25,104 +49,506 @@ func (o SyncOptions) logger() *slog.Logger {
 	return slog.Default()
 }
 
-func SyncAll(cfg *Config, opts SyncOptions) error {
-	g, ctx := errgroup.WithContext(context.Background())
-	for _, mod := range cfg.Modules {
-		g.Go(func() error {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-				return SyncModule(mod, opts)
-			}
-		})
+// SyncAll syncs all modules in cfg.
+// Modules with a matching lock entry are checked out at the locked commit;
+// the others are resolved from their revision. Unless DryRun or Frozen is set, the lock file is
+// rewritten with the result, even if some modules fail: failed modules keep their previous lock entry.
+func SyncAll(ctx context.Context, cfg *Config, opts SyncOptions) error {
+	var lock *Lock
+	var err error
+	if opts.Frozen {
+		lock, err = loadFrozenLock(cfg, opts.LockFile)
+	} else {
+		lock, err = loadLockOrEmpty(opts.LockFile)
 	}
-	if err := g.Wait(); err != nil {
+	if err != nil {
 		return err
 	}
-	opts.logger().Info("done")
-	return nil
+	if opts.Offline {
+		if opts.CacheDir == "" {
+			return errors.New("offline mode requires a cache dir")
+		}
+		opts.KeepGoing = true
+	}
+	_, err = syncAll(ctx, cfg, lock, lock.pinned, opts)
+	return err
 }
 
-func SyncModule(mod Module, opts SyncOptions) error {
-	logger := WithModule(opts.logger(), mod.Name)
-
-	tmpdir, err := os.MkdirTemp("", "demod-*")
+// syncAll syncs all modules in cfg, checking out the commit of the lock entry returned by pin
+// for each module (or resolving its revision if pin returns nil), and saves the resulting lock.
+// If some modules fail or are canceled, their entries from previous are kept and the lock is
+// saved anyway, so it matches the dests of the modules that completed; the error is returned
+// along with it.
+func syncAll(ctx context.Context, cfg *Config, previous *Lock, pin func(Module) *LockedModule, opts SyncOptions) (*Lock, error) {
+	ws, err := newWorkspace(opts, cfg.Modules)
 	if err != nil {
-		return fmt.Errorf("[%s] creating temp dir: %w", mod.Name, err)
+		return nil, err
 	}
-	defer func() { _ = os.RemoveAll(tmpdir) }()
+	defer ws.close()
 
-	workdir := filepath.Join(tmpdir, "repo")
+	locked := make([]*LockedModule, len(cfg.Modules))
+	err = forEachModule(ctx, cfg, opts, func(ctx context.Context, i int, mod Module) error {
+		entry, err := syncModule(ctx, mod, pin(mod), opts, ws)
+		if err != nil {
+			return err
+		}
+		locked[i] = entry
+		return nil
+	})
 
-	logger.Info("cloning")
-	if err := gitClone(logger, mod.Repo, workdir); err != nil {
-		return fmt.Errorf("[%s] %w", mod.Name, err)
+	// Save the lock even if the run failed: the modules that completed have replaced their dests,
+	// and the lock must keep describing what is on disk. If none completed, it still does.
+	completed := slices.ContainsFunc(locked, func(entry *LockedModule) bool { return entry != nil })
+	lock := &Lock{Version: 1}
+	for i, mod := range cfg.Modules {
+		entry := locked[i]
+		if entry == nil {
+			// The dest of a failed module was left untouched, so its previous entry still describes it.
+			entry = previous.Find(mod.Name)
+		}
+		if entry != nil {
+			lock.Modules = append(lock.Modules, *entry)
+		}
+	}
+	if !opts.DryRun && !opts.Frozen && opts.LockFile != "" && (err == nil || completed) {
+		if serr := lock.Save(opts.LockFile); serr != nil {
+			return lock, errors.Join(err, serr)
+		}
+	}
+	if err != nil {
+		return lock, err
 	}
+	opts.logger().Info("done")
+	return lock, nil
+}
 
-	if err := gitSparseCheckoutInit(logger, workdir); err != nil {
-		return fmt.Errorf("[%s] %w", mod.Name, err)
+// SyncModule syncs a single module into mod.Dest.
+// If pinned is non-nil, its commit is checked out instead of resolving mod.Revision.
+// The sync fails with a TimeoutError if it takes longer than mod.Timeout.
+// It returns the lock entry describing what was synced.
+func SyncModule(ctx context.Context, mod Module, pinned *LockedModule, opts SyncOptions) (*LockedModule, error) {
+	ws, err := newWorkspace(opts, []Module{mod})
+	if err != nil {
+		return nil, stageError(mod, StagePrepare, err)
 	}
+	defer ws.close()
+	return syncModule(ctx, mod, pinned, opts, ws)
+}
 
-	srcPaths := make([]string, len(mod.Paths))
-	for i, p := range mod.Paths {
-		srcPaths[i] = p.Src
+// syncModule is SyncModule sharing the mirrors and worktrees of ws with the other modules of a run.
+func syncModule(ctx context.Context, mod Module, pinned *LockedModule, opts SyncOptions, ws *workspace) (*LockedModule, error) {
+	logger := WithModule(opts.logger(), mod.Name)
+	ctx, cancel := moduleContext(opts.gitContext(ctx), mod)
+	defer cancel()
+	ctx, err := opts.authContext(ctx, mod)
+	if err != nil {
+		return nil, stageError(mod, StagePrepare, err)
 	}
-	if err := gitSparseCheckoutSet(logger, workdir, srcPaths); err != nil {
-		return fmt.Errorf("[%s] %w", mod.Name, err)
+
+	workdir, commit, tag, err := checkoutModule(ctx, logger, mod, pinned, ws)
+	if err != nil {
+		return nil, err
 	}
 
-	logger.Info("checkout", "revision", mod.Revision)
-	if err := gitCheckout(logger, workdir, mod.Revision); err != nil {
-		return fmt.Errorf("[%s] %w", mod.Name, err)
+	entry := &LockedModule{
+		Name:     mod.Name,
+		Repo:     mod.Repo,
+		Revision: mod.Revision,
+		Commit:   commit,
+		Tag:      tag,
+		Paths:    make([]LockedPath, len(mod.Paths)),
+	}
+	for i, p := range mod.Paths {
+		entry.Paths[i] = LockedPath{Src: p.Src, As: p.As, Exclude: p.Exclude, Symlinks: p.Symlinks}
 	}
 
 	if opts.DryRun {
 		logger.Info("would sync", "dest", mod.Dest)
 		for _, p := range mod.Paths {
-			destPath := p.As
-			if destPath == "" {
-				destPath = p.Src
-			}
-			logger.Info("would copy", "src", p.Src, "dest", filepath.Join(mod.Dest, destPath), "exclude", p.Exclude)
+			logger.Info("would copy", "src", p.Src, "dest", filepath.Join(mod.Dest, p.dest()), "exclude", p.Exclude)
 		}
-		return nil
+		return entry, nil
 	}
 
 	logger.Info("syncing", "dest", mod.Dest)
 
-	if err := os.RemoveAll(mod.Dest); err != nil {
-		return fmt.Errorf("[%s] removing dest: %w", mod.Name, err)
+	previous, err := readManifestOrEmpty(mod.Dest)
+	if err != nil {
+		return nil, stageError(mod, StagePrepare, err)
+	}
+
+	// Build the new dest in a staging directory and swap it into place only once everything
+	// has been copied, so a failure leaves the previous contents intact.
+	stage, err := stageDir(mod.Dest)
+	if err != nil {
+		return nil, stageError(mod, StagePrepare, fmt.Errorf("creating staging dir: %w", err))
 	}
+	defer func() { _ = os.RemoveAll(stage) }()
 
-	for _, p := range mod.Paths {
-		src := filepath.Join(workdir, p.Src)
-		destPath := p.As
-		if destPath == "" {
-			destPath = p.Src
+	// A dest synced before manifests were written has no record of the files demod wrote, so
+	// its first sync replaces everything under the module paths. Afterwards only the manifest
+	// decides what demod owns.
+	var owned []Path
+	if _, err := os.Lstat(filepath.Join(mod.Dest, ManifestFileName)); errors.Is(err, fs.ErrNotExist) {
+		owned = mod.Paths
+	}
+	dropped, err := carryOver(mod.Dest, stage, previous, owned)
+	if err != nil {
+		return nil, stageError(mod, StageCopy, fmt.Errorf("staging unmanaged files: %w", err))
+	}
+	c := newCopier(mod.Dest)
+	if err := copyPaths(workdir, stage, mod.Paths, c); err != nil {
+		return nil, stageError(mod, StageCopy, err)
+	}
+	manifest := c.manifest
+	if err := verifyDigests(manifest, mod.Paths); err != nil {
+		return nil, stageError(mod, StageVerify, err)
+	}
+	stale := append(staleFiles(previous, manifest), dropped...)
+	for _, name := range stale {
+		logger.Debug("removed", "file", name)
+	}
+	stats := c.stats
+	stats.Removed = len(stale)
+
+	// Past this point the dest is replaced as a whole, so an interrupted sync must stop here.
+	if ctx.Err() != nil {
+		return nil, stageError(mod, StageWrite, context.Cause(ctx))
+	}
+	if stats.changed() || !maps.Equal(previous, manifest) {
+		if err := manifest.write(stage); err != nil {
+			return nil, stageError(mod, StageWrite, err)
+		}
+		if err := replaceDir(stage, mod.Dest); err != nil {
+			return nil, stageError(mod, StageWrite, err)
 		}
-		if err := copyDir(src, mod.Dest, destPath, p.Exclude); err != nil {
-			return fmt.Errorf("[%s] copying: %w", mod.Name, err)
+	}
+	logger.Info("synced", "added", stats.Added, "updated", stats.Updated, "removed", stats.Removed, "unchanged", stats.Unchanged)
+	for i, p := range mod.Paths {
+		entry.Paths[i].Hash = manifest.hashPath(p.dest())
+	}
+
+	return entry, nil
+}
+
+// checkoutModule fetches the module into its mirror and checks out the module paths at the commit
+// of pinned, or at mod.Revision if pinned is nil, in a worktree of ws that may be shared with
+// other modules of the same repository. In offline mode, nothing is fetched and the revision is
+// resolved from the mirror.
+// It returns the worktree, the commit that was checked out, and the tag it was resolved from, if any.
+// Errors are returned as a *StageError.
+func checkoutModule(ctx context.Context, logger *slog.Logger, mod Module, pinned *LockedModule, ws *workspace) (workdir, commit, tag string, err error) {
+	open := openMirror
+	if ws.offline {
+		open = openCachedMirror
+	}
+	mirror, unlock, err := open(ctx, logger, ws.cacheDir, mod.Repo)
+	if err != nil {
+		return "", "", "", stageError(mod, StageFetch, err)
+	}
+	defer unlock()
+
+	switch {
+	case ws.offline && pinned == nil:
+		if commit, tag, err = resolveCached(ctx, logger, mirror, mod.Revision); err != nil {
+			return "", "", "", stageError(mod, StageFetch, err)
+		}
+		logger.Info("resolved from cache", "revision", mod.Revision, "commit", commit)
+	case ws.offline:
+		commit, tag = pinned.Commit, pinned.Tag
+		if err := checkCached(ctx, logger, mirror, commit); err != nil {
+			return "", "", "", stageError(mod, StageFetch, err)
+		}
+	case pinned == nil:
+		logger.Info("fetching", "revision", mod.Revision)
+		err := withRemotes(ctx, logger, mirror, mod.remotes(), func() (err error) {
+			commit, tag, err = fetchRevision(ctx, logger, mirror, mod.Revision)
+			return err
+		})
+		if err != nil {
+			return "", "", "", stageError(mod, StageFetch, err)
+		}
+		if tag != "" {
+			logger.Info("resolved", "revision", mod.Revision, "tag", tag, "commit", commit)
+		} else {
+			logger.Info("resolved", "revision", mod.Revision, "commit", commit)
+		}
+	default:
+		commit, tag = pinned.Commit, pinned.Tag
+		logger.Info("fetching", "revision", commit)
+		err := withRemotes(ctx, logger, mirror, mod.remotes(), func() error {
+			return fetchCommit(ctx, logger, mirror, commit, commit)
+		})
+		if err != nil {
+			return "", "", "", stageError(mod, StageFetch, err)
+		}
+	}
+
+	if mod.Verify != nil {
+		if err := verifySignature(ctx, logger, mirror, mod.Verify, commit, tag); err != nil {
+			return "", "", "", stageError(mod, StageVerify, err)
+		}
+	}
+	if err := verifyTrees(ctx, logger, mirror, commit, mod.Paths); err != nil {
+		return "", "", "", stageError(mod, StageVerify, err)
+	}
+
+	workdir, stage, err := ws.checkout(ctx, logger, mirror, mod, commit)
+	if err != nil {
+		return "", "", "", stageError(mod, stage, err)
+	}
+	return workdir, commit, tag, nil
+}
+
+// gitContext returns ctx carrying the settings of o that apply to every git command.
+func (o SyncOptions) gitContext(ctx context.Context) context.Context {
+	if o.Offline {
+		ctx = withGitEnv(ctx, offlineEnv)
+	}
+	return withRetry(ctx, o.Retry)
+}
+
+// authContext applies the credentials of mod to ctx. Offline runs never talk to a remote,
+// so they need no credentials.
+func (o SyncOptions) authContext(ctx context.Context, mod Module) (context.Context, error) {
+	if o.Offline {
+		return ctx, nil
+	}
+	return mod.Auth.gitContext(ctx, mod.Repo)
+}
+
+// limiter returns a limiter for the job limits of o.
+func (o SyncOptions) limiter() *limiter {
+	jobs := o.Jobs
+	if jobs <= 0 {
+		jobs = DefaultJobs
+	}
+	return newLimiter(jobs, o.JobsPerHost)
+}
+
+// cacheDir returns the cache directory to use, falling back to a directory under tmpdir
+// when no persistent cache is configured.
+func (o SyncOptions) cacheDir(tmpdir string) string {
+	if o.CacheDir != "" {
+		return o.CacheDir
+	}
+	return filepath.Join(tmpdir, "cache")
+}
+
+// SyncStats counts the files a module sync added, updated, removed or left unchanged.
+type SyncStats struct {
+	Added     int
+	Updated   int
+	Removed   int
+	Unchanged int
+}
+
+func (s SyncStats) changed() bool {
+	return s.Added > 0 || s.Updated > 0 || s.Removed > 0
+}
+
+// copier writes files into a destination tree and records them in a manifest.
+// If base is set, files whose content and mode are identical to the file at the same path
+// under base are hard-linked from base instead of copied, so unchanged files keep their mtime.
+type copier struct {
+	base     string
+	manifest Manifest
+	stats    SyncStats
+}
+
+func newCopier(base string) *copier {
+	return &copier{base: base, manifest: make(Manifest)}
+}
+
+// existing returns the path of name under base, or "" if the copier has no base.
+func (c *copier) existing(name string) string {
+	if c.base == "" {
+		return ""
+	}
+	return filepath.Join(c.base, filepath.FromSlash(name))
+}
+
+// copy writes the regular file src to target, where name is the slash-separated path of target
+// relative to the module dest. The file gets the permission bits git records for src.
+func (c *copier) copy(src, target, name string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	sum, err := hashFile(src)
+	if err != nil {
+		return err
+	}
+	c.manifest[name] = sum
+
+	if err := prepareTarget(target); err != nil {
+		return err
+	}
+
+	existing := c.existing(name)
+	same, err := sameFile(existing, info, sum)
+	switch {
+	case existing == "" || errors.Is(err, fs.ErrNotExist):
+		c.stats.Added++
+	case err != nil:
+		return err
+	case same:
+		c.stats.Unchanged++
+		if err := os.Link(existing, target); err == nil {
+			return nil
 		}
+	default:
+		c.stats.Updated++
 	}
 
+	if err := copyFile(src, target); err != nil {
+		return err
+	}
+	return os.Chmod(target, gitMode(info.Mode()))
+}
+
+// symlink creates a symlink to link at target, where name is the slash-separated path of target
+// relative to the module dest.
+func (c *copier) symlink(link, target, name string) error {
+	c.manifest[name] = symlinkDigest(link)
+
+	if err := prepareTarget(target); err != nil {
+		return err
+	}
+
+	existing := c.existing(name)
+	current, err := readSymlink(existing)
+	switch {
+	case existing == "" || errors.Is(err, fs.ErrNotExist):
+		c.stats.Added++
+	case err != nil:
+		return err
+	case current == link:
+		c.stats.Unchanged++
+	default:
+		c.stats.Updated++
+	}
+
+	return os.Symlink(link, target)
+}
+
+// prepareTarget creates the parent directory of target and removes anything already at target.
+// target may be a link to a file carried over from the current dest; it must never be written through.
+func prepareTarget(target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
 	return nil
 }
 
-func copyDir(src, dest, destPath string, exclude []string) error {
-	return filepath.WalkDir(src, func(fpath string, d fs.DirEntry, err error) error {
+// sameFile reports whether path is a regular file with the size and content digest sum of the
+// file described by info, and the permission bits git records for it.
+func sameFile(path string, info fs.FileInfo, sum string) (bool, error) {
+	if path == "" {
+		return false, nil
+	}
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return false, err
+	}
+	if !fi.Mode().IsRegular() || fi.Size() != info.Size() || fi.Mode().Perm() != gitMode(info.Mode()) {
+		return false, nil
+	}
+	existing, err := hashFile(path)
+	if err != nil {
+		return false, err
+	}
+	return existing == sum, nil
+}
+
+// readSymlink returns the target of the symlink at path, or "" if path is not a symlink.
+func readSymlink(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return "", err
+	}
+	if fi.Mode()&fs.ModeSymlink == 0 {
+		return "", nil
+	}
+	return os.Readlink(path)
+}
+
+// gitMode returns the permission bits git records for a file with mode m:
+// 0755 if it has any executable bit set, 0644 otherwise.
+func gitMode(m fs.FileMode) fs.FileMode {
+	if m&0o111 != 0 {
+		return 0o755
+	}
+	return 0o644
+}
+
+// copyPaths copies the module paths from the checkout in workdir into dest.
+// If c is non-nil, it is used to write the files.
+func copyPaths(workdir, dest string, paths []Path, c *copier) error {
+	for _, p := range paths {
+		if err := copyPath(workdir, dest, p, paths, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyDir copies the files under p.Src in the checkout at root to p.dest() under dest,
+// applying the exclude patterns and symlink policy of p.
+// If c is nil, the files are written without comparing them to an existing dest.
+func copyDir(root, dest string, p Path, c *copier) error {
+	return copyPath(root, dest, p, []Path{p}, c)
+}
+
+// copyPath is copyDir for p, one of the module paths in paths. Followed symlinks must point
+// under the src of one of paths: the checkout may hold other files, depending on which other
+// modules share it, and the result must not depend on them.
+func copyPath(root, dest string, p Path, paths []Path, c *copier) error {
+	if c == nil {
+		c = newCopier("")
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	w := &pathCopier{copier: c, root: realRoot, dest: dest, path: p, paths: paths, following: make(map[string]bool)}
+	return w.copyTree(filepath.Join(root, p.Src), "")
+}
+
+// pathCopier copies a single module path.
+type pathCopier struct {
+	*copier
+	root string
+	dest string
+	path Path
+	// paths are all the paths of the module.
+	paths []Path
+	// following holds the directories of followed symlinks being copied, to detect cycles.
+	following map[string]bool
+}
+
+// copyTree copies the tree at dir, whose path relative to the module path's src is prefix.
+func (w *pathCopier) copyTree(dir, prefix string) error {
+	return filepath.WalkDir(dir, func(fpath string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
-		rel, err := filepath.Rel(src, fpath)
+		rel, err := filepath.Rel(dir, fpath)
 		if err != nil {
 			return err
 		}
+		rel = filepath.Join(prefix, rel)
 
 		if rel != "." {
-			for _, pattern := range exclude {
-				matched, matchErr := doublestar.Match(pattern, rel)
+			for _, pattern := range w.path.Exclude {
+				matched, matchErr := doublestar.Match(pattern, filepath.ToSlash(rel))
 				if matchErr != nil {
 					return fmt.Errorf("invalid exclude pattern %q: %w", pattern, matchErr)
 				}
@@ -135,16 +561,110 @@ func copyDir(src, dest, destPath string, exclude []string) error {
 			}
 		}
 
-		target := filepath.Join(dest, destPath, rel)
+		target := filepath.Join(w.dest, w.path.dest(), rel)
+		name := filepath.ToSlash(filepath.Join(w.path.dest(), rel))
 
-		if d.IsDir() {
+		switch {
+		case d.IsDir():
 			return os.MkdirAll(target, 0o755)
+		case d.Type()&fs.ModeSymlink != 0:
+			return w.copySymlink(fpath, target, name, rel)
+		default:
+			return w.copy(fpath, target, name)
 		}
-
-		return copyFile(fpath, target)
 	})
 }
 
+// inPaths reports whether rel, a path relative to the repository root, is in the sparse checkout
+// cone of the module paths: under the src of one of them or, if rel is a file, directly in the
+// repository root or in a directory that contains the src of one of them.
+func (w *pathCopier) inPaths(rel string, isDir bool) bool {
+	parent := filepath.Dir(rel)
+	for _, p := range w.paths {
+		src := filepath.Clean(p.Src)
+		if src == "." || rel == src || strings.HasPrefix(rel, src+string(filepath.Separator)) {
+			return true
+		}
+		if isDir {
+			continue
+		}
+		for dir := filepath.Dir(src); ; dir = filepath.Dir(dir) {
+			if parent == dir {
+				return true
+			}
+			if dir == "." {
+				break
+			}
+		}
+	}
+	return false
+}
+
+func (w *pathCopier) copySymlink(fpath, target, name, rel string) error {
+	switch w.path.symlinks() {
+	case SymlinksSkip:
+		return nil
+
+	case SymlinksFollow:
+		resolved, err := filepath.EvalSymlinks(fpath)
+		if err != nil {
+			return fmt.Errorf("following symlink %s: %w", name, err)
+		}
+		inRoot, err := filepath.Rel(w.root, resolved)
+		if err != nil || !filepath.IsLocal(inRoot) {
+			return fmt.Errorf("symlink %s points outside the repository", name)
+		}
+		info, err := os.Stat(resolved)
+		if err != nil {
+			return err
+		}
+		if !w.inPaths(inRoot, info.IsDir()) {
+			return fmt.Errorf("symlink %s points outside the module paths", name)
+		}
+		if !info.IsDir() {
+			return w.copy(resolved, target, name)
+		}
+		if w.following[resolved] {
+			return fmt.Errorf("symlink %s forms a cycle", name)
+		}
+		w.following[resolved] = true
+		defer delete(w.following, resolved)
+		return w.copyTree(resolved, rel)
+
+	default:
+		link, err := os.Readlink(fpath)
+		if err != nil {
+			return err
+		}
+		if filepath.IsAbs(link) || !filepath.IsLocal(filepath.Join(filepath.Dir(filepath.FromSlash(name)), link)) {
+			return fmt.Errorf("symlink %s -> %s points outside the module dest", name, link)
+		}
+		if !leadingDotDotOnly(link) {
+			return fmt.Errorf("symlink %s -> %s has .. after a path component, which may be a symlink", name, link)
+		}
+		return w.symlink(link, target, name)
+	}
+}
+
+// leadingDotDotOnly reports whether the relative link target link only has ".." components at
+// its start. The lexical check of a preserved link is only sound for such targets: ".." after
+// a component that is itself a symlink leaves the directory the link lexically points to.
+func leadingDotDotOnly(link string) bool {
+	parents := true
+	for _, elem := range strings.Split(filepath.ToSlash(link), "/") {
+		switch elem {
+		case "..":
+			if !parents {
+				return false
+			}
+		case "", ".":
+		default:
+			parents = false
+		}
+	}
+	return true
+}
+
 func copyFile(src, dst string) error {
 	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
 		return err
@@ -156,7 +676,12 @@ func copyFile(src, dst string) error {
 	}
 	defer func() { _ = in.Close() }()
 
-	out, err := os.Create(dst)
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
 	if err != nil {
 		return err
 	}
@@ -1,9 +1,14 @@
 package demod
 
 import (
+	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCopyFile(t *testing.T) {
@@ -28,6 +33,28 @@ func TestCopyFile(t *testing.T) {
 	}
 }
 
+func TestCopyFile_PreservesMode(t *testing.T) {
+	dir := t.TempDir()
+
+	src := filepath.Join(dir, "run.sh")
+	if err := os.WriteFile(src, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(dir, "out", "run.sh")
+	if err := copyFile(src, dst); err != nil {
+		t.Fatalf("copyFile: %v", err)
+	}
+
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o755 {
+		t.Errorf("mode = %v, want %v", info.Mode().Perm(), os.FileMode(0o755))
+	}
+}
+
 func TestCopyDir(t *testing.T) {
 	t.Run("with destPath", func(t *testing.T) {
 		srcDir := t.TempDir()
@@ -44,7 +71,7 @@ func TestCopyDir(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		if err := copyDir(srcDir, destDir, "lib", nil); err != nil {
+		if err := copyDir(srcDir, destDir, Path{As: "lib"}, nil); err != nil {
 			t.Fatalf("copyDir: %v", err)
 		}
 
@@ -62,7 +89,7 @@ func TestCopyDir(t *testing.T) {
 		}
 
 		// destPath="lib" → files at destDir/lib/a.txt
-		if err := copyDir(srcDir, destDir, "lib", nil); err != nil {
+		if err := copyDir(srcDir, destDir, Path{As: "lib"}, nil); err != nil {
 			t.Fatalf("copyDir: %v", err)
 		}
 
@@ -78,7 +105,7 @@ func TestCopyDir(t *testing.T) {
 		}
 
 		// destPath="" → files at destDir/a.txt
-		if err := copyDir(srcDir, destDir, "", nil); err != nil {
+		if err := copyDir(srcDir, destDir, Path{}, nil); err != nil {
 			t.Fatalf("copyDir: %v", err)
 		}
 
@@ -99,7 +126,7 @@ func TestCopyDir(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		if err := copyDir(srcDir, destDir, "lib", []string{"BUILD.bazel", "*.md"}); err != nil {
+		if err := copyDir(srcDir, destDir, Path{As: "lib", Exclude: []string{"BUILD.bazel", "*.md"}}, nil); err != nil {
 			t.Fatalf("copyDir: %v", err)
 		}
 
@@ -126,7 +153,7 @@ func TestCopyDir(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		if err := copyDir(srcDir, destDir, "lib", []string{"testdata/**"}); err != nil {
+		if err := copyDir(srcDir, destDir, Path{As: "lib", Exclude: []string{"testdata/**"}}, nil); err != nil {
 			t.Fatalf("copyDir: %v", err)
 		}
 
@@ -137,6 +164,265 @@ func TestCopyDir(t *testing.T) {
 	})
 }
 
+func TestCopyDir_Symlinks(t *testing.T) {
+	// setup creates a checkout with:
+	//
+	//	lib/a.txt
+	//	lib/sub/b.txt
+	//	lib/link.txt -> a.txt
+	//	lib/linkdir  -> sub
+	//	outside.txt
+	setup := func(t *testing.T) string {
+		t.Helper()
+		root := t.TempDir()
+		writeFiles(t, root, map[string]string{
+			"lib/a.txt":     "aaa",
+			"lib/sub/b.txt": "bbb",
+			"outside.txt":   "outside",
+		})
+		if err := os.Symlink("a.txt", filepath.Join(root, "lib", "link.txt")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("sub", filepath.Join(root, "lib", "linkdir")); err != nil {
+			t.Fatal(err)
+		}
+		return root
+	}
+
+	t.Run("preserve", func(t *testing.T) {
+		root := setup(t)
+		dest := t.TempDir()
+		c := newCopier("")
+		if err := copyDir(root, dest, Path{Src: "lib"}, c); err != nil {
+			t.Fatalf("copyDir: %v", err)
+		}
+		if link, err := os.Readlink(filepath.Join(dest, "lib", "link.txt")); err != nil || link != "a.txt" {
+			t.Errorf("link.txt = %q, %v, want symlink to a.txt", link, err)
+		}
+		if got := c.manifest["lib/link.txt"]; got != symlinkDigest("a.txt") {
+			t.Errorf("manifest digest = %q, want %q", got, symlinkDigest("a.txt"))
+		}
+	})
+
+	t.Run("preserve rejects links outside dest", func(t *testing.T) {
+		root := setup(t)
+		if err := os.Symlink("../../outside.txt", filepath.Join(root, "lib", "escape")); err != nil {
+			t.Fatal(err)
+		}
+		if err := copyDir(root, t.TempDir(), Path{Src: "lib"}, nil); err == nil {
+			t.Fatal("expected error for symlink pointing outside the module dest")
+		}
+	})
+
+	t.Run("preserve rejects links escaping through another link", func(t *testing.T) {
+		root := setup(t)
+		// d -> .. stays in the dest, but d/../secret resolves to the parent of the dest.
+		if err := os.Symlink("..", filepath.Join(root, "lib", "d")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink("d/../secret", filepath.Join(root, "lib", "esc")); err != nil {
+			t.Fatal(err)
+		}
+		dest := filepath.Join(t.TempDir(), "dest")
+		if err := copyDir(root, dest, Path{Src: "lib"}, nil); err == nil {
+			t.Fatal("expected error for symlink escaping the module dest through another symlink")
+		}
+	})
+
+	t.Run("follow", func(t *testing.T) {
+		root := setup(t)
+		dest := t.TempDir()
+		if err := copyDir(root, dest, Path{Src: "lib", Symlinks: SymlinksFollow}, nil); err != nil {
+			t.Fatalf("copyDir: %v", err)
+		}
+		assertFileContent(t, filepath.Join(dest, "lib", "link.txt"), "aaa")
+		assertFileContent(t, filepath.Join(dest, "lib", "linkdir", "b.txt"), "bbb")
+		if info, err := os.Lstat(filepath.Join(dest, "lib", "link.txt")); err != nil || !info.Mode().IsRegular() {
+			t.Errorf("expected link.txt to be a regular file, got %v, %v", info, err)
+		}
+	})
+
+	t.Run("follow rejects links outside repository", func(t *testing.T) {
+		root := setup(t)
+		outside := filepath.Join(t.TempDir(), "secret.txt")
+		if err := os.WriteFile(outside, []byte("secret"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(outside, filepath.Join(root, "lib", "secret.txt")); err != nil {
+			t.Fatal(err)
+		}
+		if err := copyDir(root, t.TempDir(), Path{Src: "lib", Symlinks: SymlinksFollow}, nil); err == nil {
+			t.Fatal("expected error for symlink pointing outside the repository")
+		}
+	})
+
+	t.Run("follow only allows links into the module paths", func(t *testing.T) {
+		root := setup(t)
+		writeFiles(t, root, map[string]string{"shared/c.txt": "ccc"})
+		if err := os.Symlink(filepath.Join("..", "shared"), filepath.Join(root, "lib", "shared")); err != nil {
+			t.Fatal(err)
+		}
+		lib := Path{Src: "lib", Symlinks: SymlinksFollow}
+
+		// A checkout shared with another module may contain shared/, but it is not a path of this module.
+		if err := copyDir(root, t.TempDir(), lib, nil); err == nil || !strings.Contains(err.Error(), "outside the module paths") {
+			t.Fatalf("err = %v, want an error for a symlink outside the module paths", err)
+		}
+
+		dest := t.TempDir()
+		if err := copyPaths(root, dest, []Path{lib, {Src: "shared"}}, nil); err != nil {
+			t.Fatalf("copyPaths: %v", err)
+		}
+		assertFileContent(t, filepath.Join(dest, "lib", "shared", "c.txt"), "ccc")
+	})
+
+	t.Run("follow allows files in the parent dirs of the module paths", func(t *testing.T) {
+		root := setup(t)
+		writeFiles(t, root, map[string]string{
+			"LICENSE":         "license",
+			"pkg/NOTICE":      "notice",
+			"pkg/lib/x.txt":   "xxx",
+			"pkg/other/y.txt": "yyy",
+		})
+		for link, target := range map[string]string{
+			"pkg/lib/LICENSE": "../../LICENSE",
+			"pkg/lib/NOTICE":  "../NOTICE",
+		} {
+			if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		dest := t.TempDir()
+		if err := copyDir(root, dest, Path{Src: "pkg/lib", As: "lib", Symlinks: SymlinksFollow}, nil); err != nil {
+			t.Fatalf("copyDir: %v", err)
+		}
+		assertFileContent(t, filepath.Join(dest, "lib", "LICENSE"), "license")
+		assertFileContent(t, filepath.Join(dest, "lib", "NOTICE"), "notice")
+
+		// Only files directly in those dirs are in the cone, not their other subdirectories.
+		if err := os.Symlink(filepath.Join("..", "other", "y.txt"), filepath.Join(root, "pkg", "lib", "y.txt")); err != nil {
+			t.Fatal(err)
+		}
+		if err := copyDir(root, t.TempDir(), Path{Src: "pkg/lib", Symlinks: SymlinksFollow}, nil); err == nil || !strings.Contains(err.Error(), "outside the module paths") {
+			t.Fatalf("err = %v, want an error for a symlink outside the module paths", err)
+		}
+	})
+
+	t.Run("follow allows files in the repository root", func(t *testing.T) {
+		root := setup(t)
+		writeFiles(t, root, map[string]string{"LICENSE": "license"})
+		if err := os.Symlink(filepath.Join("..", "LICENSE"), filepath.Join(root, "lib", "LICENSE")); err != nil {
+			t.Fatal(err)
+		}
+		dest := t.TempDir()
+		if err := copyDir(root, dest, Path{Src: "lib", Symlinks: SymlinksFollow}, nil); err != nil {
+			t.Fatalf("copyDir: %v", err)
+		}
+		assertFileContent(t, filepath.Join(dest, "lib", "LICENSE"), "license")
+	})
+
+	t.Run("follow detects cycles", func(t *testing.T) {
+		root := setup(t)
+		if err := os.Symlink("..", filepath.Join(root, "lib", "sub", "up")); err != nil {
+			t.Fatal(err)
+		}
+		if err := copyDir(root, t.TempDir(), Path{Src: "lib", Symlinks: SymlinksFollow}, nil); err == nil {
+			t.Fatal("expected error for symlink cycle")
+		}
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		root := setup(t)
+		dest := t.TempDir()
+		if err := copyDir(root, dest, Path{Src: "lib", Symlinks: SymlinksSkip}, nil); err != nil {
+			t.Fatalf("copyDir: %v", err)
+		}
+		assertFileContent(t, filepath.Join(dest, "lib", "a.txt"), "aaa")
+		if _, err := os.Lstat(filepath.Join(dest, "lib", "link.txt")); !os.IsNotExist(err) {
+			t.Errorf("expected link.txt to be skipped, got err: %v", err)
+		}
+	})
+}
+
+func TestCopier(t *testing.T) {
+	src := t.TempDir()
+	base := t.TempDir()
+	stage := t.TempDir()
+	writeFiles(t, src, map[string]string{"same.txt": "same", "changed.txt": "new", "new.txt": "new"})
+	writeFiles(t, base, map[string]string{"same.txt": "same", "changed.txt": "old"})
+
+	c := newCopier(base)
+	if err := copyDir(src, stage, Path{}, c); err != nil {
+		t.Fatalf("copyDir: %v", err)
+	}
+
+	want := SyncStats{Added: 1, Updated: 1, Unchanged: 1}
+	if c.stats != want {
+		t.Errorf("stats = %+v, want %+v", c.stats, want)
+	}
+	if len(c.manifest) != 3 {
+		t.Errorf("len(manifest) = %d, want 3", len(c.manifest))
+	}
+	assertFileContent(t, filepath.Join(stage, "changed.txt"), "new")
+	assertFileContent(t, filepath.Join(stage, "new.txt"), "new")
+
+	// Unchanged files are linked from base rather than rewritten.
+	staged, err := os.Stat(filepath.Join(stage, "same.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing, err := os.Stat(filepath.Join(base, "same.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(staged, existing) {
+		t.Error("expected unchanged file to be linked from base")
+	}
+}
+
+func TestCopier_ModeChange(t *testing.T) {
+	src := t.TempDir()
+	base := t.TempDir()
+	stage := t.TempDir()
+	writeFiles(t, src, map[string]string{"run.sh": "#!/bin/sh\n"})
+	writeFiles(t, base, map[string]string{"run.sh": "#!/bin/sh\n"})
+	if err := os.Chmod(filepath.Join(src, "run.sh"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newCopier(base)
+	if err := copyDir(src, stage, Path{}, c); err != nil {
+		t.Fatalf("copyDir: %v", err)
+	}
+	if c.stats.Updated != 1 {
+		t.Errorf("stats = %+v, want 1 updated", c.stats)
+	}
+	info, err := os.Stat(filepath.Join(stage, "run.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o755 {
+		t.Errorf("mode = %v, want %v", info.Mode().Perm(), os.FileMode(0o755))
+	}
+}
+
+func TestCopier_DoesNotWriteThroughLinks(t *testing.T) {
+	src := t.TempDir()
+	base := t.TempDir()
+	stage := t.TempDir()
+	writeFiles(t, src, map[string]string{"a.txt": "new"})
+	writeFiles(t, base, map[string]string{"a.txt": "old"})
+	if err := os.Link(filepath.Join(base, "a.txt"), filepath.Join(stage, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := copyDir(src, stage, Path{}, newCopier(base)); err != nil {
+		t.Fatalf("copyDir: %v", err)
+	}
+	assertFileContent(t, filepath.Join(stage, "a.txt"), "new")
+	assertFileContent(t, filepath.Join(base, "a.txt"), "old")
+}
+
 func TestSyncModule(t *testing.T) {
 	bare := setupBareRepo(t)
 
@@ -149,7 +435,7 @@ func TestSyncModule(t *testing.T) {
 			Dest:     dest,
 			Paths:    []Path{{Src: "src/lib", As: "lib"}},
 		}
-		if err := SyncModule(mod, SyncOptions{}); err != nil {
+		if _, err := SyncModule(t.Context(), mod, nil, SyncOptions{}); err != nil {
 			t.Fatalf("SyncModule: %v", err)
 		}
 		assertFileContent(t, filepath.Join(dest, "lib", "a.txt"), "aaa")
@@ -165,7 +451,7 @@ func TestSyncModule(t *testing.T) {
 			Dest:     dest,
 			Paths:    []Path{{Src: "docs"}},
 		}
-		if err := SyncModule(mod, SyncOptions{}); err != nil {
+		if _, err := SyncModule(t.Context(), mod, nil, SyncOptions{}); err != nil {
 			t.Fatalf("SyncModule: %v", err)
 		}
 		assertFileContent(t, filepath.Join(dest, "docs", "readme.txt"), "readme")
@@ -180,7 +466,7 @@ func TestSyncModule(t *testing.T) {
 			Dest:     dest,
 			Paths:    []Path{{Src: "src/lib", As: "lib"}},
 		}
-		if err := SyncModule(mod, SyncOptions{DryRun: true}); err != nil {
+		if _, err := SyncModule(t.Context(), mod, nil, SyncOptions{DryRun: true}); err != nil {
 			t.Fatalf("SyncModule dry-run: %v", err)
 		}
 		if _, err := os.Stat(dest); !os.IsNotExist(err) {
@@ -188,6 +474,24 @@ func TestSyncModule(t *testing.T) {
 		}
 	})
 
+	t.Run("unsigned commit fails verification", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		mod := Module{
+			Name:     "test",
+			Repo:     bare,
+			Revision: "main",
+			Dest:     dest,
+			Paths:    []Path{{Src: "src/lib", As: "lib"}},
+			Verify:   &SignaturePolicy{AllowedSigners: os.DevNull},
+		}
+		if _, err := SyncModule(t.Context(), mod, nil, SyncOptions{}); err == nil {
+			t.Fatal("expected error for unsigned commit")
+		}
+		if _, err := os.Stat(dest); !os.IsNotExist(err) {
+			t.Errorf("expected dest dir to not exist, got err: %v", err)
+		}
+	})
+
 	t.Run("exclude filters files", func(t *testing.T) {
 		dest := filepath.Join(t.TempDir(), "dest")
 		mod := Module{
@@ -197,7 +501,7 @@ func TestSyncModule(t *testing.T) {
 			Dest:     dest,
 			Paths:    []Path{{Src: "src/lib", As: "lib", Exclude: []string{"b.txt"}}},
 		}
-		if err := SyncModule(mod, SyncOptions{}); err != nil {
+		if _, err := SyncModule(t.Context(), mod, nil, SyncOptions{}); err != nil {
 			t.Fatalf("SyncModule: %v", err)
 		}
 		assertFileContent(t, filepath.Join(dest, "lib", "a.txt"), "aaa")
@@ -206,6 +510,145 @@ func TestSyncModule(t *testing.T) {
 		}
 	})
 
+	t.Run("keeps files not owned by demod", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		mod := Module{
+			Name:     "test",
+			Repo:     bare,
+			Revision: "main",
+			Dest:     dest,
+			Paths:    []Path{{Src: "src/lib", As: "lib"}},
+		}
+		if _, err := SyncModule(t.Context(), mod, nil, SyncOptions{}); err != nil {
+			t.Fatalf("SyncModule: %v", err)
+		}
+		writeFiles(t, dest, map[string]string{"BUILD.bazel": "build", "lib/README.md": "readme"})
+
+		mod.Paths = []Path{{Src: "docs"}}
+		if _, err := SyncModule(t.Context(), mod, nil, SyncOptions{}); err != nil {
+			t.Fatalf("SyncModule: %v", err)
+		}
+		assertFileContent(t, filepath.Join(dest, "docs", "readme.txt"), "readme")
+		assertFileContent(t, filepath.Join(dest, "BUILD.bazel"), "build")
+		assertFileContent(t, filepath.Join(dest, "lib", "README.md"), "readme")
+		if _, err := os.Stat(filepath.Join(dest, "lib", "a.txt")); !os.IsNotExist(err) {
+			t.Errorf("expected stale lib/a.txt to be removed, got err: %v", err)
+		}
+	})
+
+	t.Run("keeps files added under the module paths", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		mod := Module{
+			Name:     "test",
+			Repo:     bare,
+			Revision: "main",
+			Dest:     dest,
+			Paths:    []Path{{Src: "src/lib", As: "lib"}},
+		}
+		if _, err := SyncModule(t.Context(), mod, nil, SyncOptions{}); err != nil {
+			t.Fatalf("SyncModule: %v", err)
+		}
+		writeFiles(t, dest, map[string]string{"lib/BUILD.bazel": "build"})
+
+		if _, err := SyncModule(t.Context(), mod, nil, SyncOptions{}); err != nil {
+			t.Fatalf("SyncModule: %v", err)
+		}
+		assertFileContent(t, filepath.Join(dest, "lib", "BUILD.bazel"), "build")
+		if mismatches, err := Verify(&Config{Modules: []Module{mod}}, SyncOptions{}); err != nil || len(mismatches) != 0 {
+			t.Errorf("Verify = %+v, %v, want no mismatches", mismatches, err)
+		}
+	})
+
+	t.Run("dest synced before manifests", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		mod := Module{
+			Name:     "test",
+			Repo:     bare,
+			Revision: "main",
+			Dest:     dest,
+			Paths:    []Path{{Src: "src/lib", As: "lib"}},
+		}
+		// A previous release wrote the module paths without a manifest, including a file upstream has since deleted.
+		writeFiles(t, dest, map[string]string{"lib/a.txt": "aaa", "lib/deleted.txt": "gone upstream", "BUILD.bazel": "build"})
+
+		if _, err := SyncModule(t.Context(), mod, nil, SyncOptions{}); err != nil {
+			t.Fatalf("SyncModule: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dest, "lib", "deleted.txt")); !os.IsNotExist(err) {
+			t.Errorf("expected lib/deleted.txt to be removed, got err: %v", err)
+		}
+		assertFileContent(t, filepath.Join(dest, "lib", "a.txt"), "aaa")
+		assertFileContent(t, filepath.Join(dest, "BUILD.bazel"), "build")
+		manifest, err := readManifest(dest)
+		if err != nil {
+			t.Fatalf("readManifest: %v", err)
+		}
+		if got := manifest.files(); !slices.Equal(got, []string{"lib/a.txt", "lib/b.txt"}) {
+			t.Errorf("manifest files = %v, want [lib/a.txt lib/b.txt]", got)
+		}
+	})
+
+	t.Run("failed copy keeps previous contents", func(t *testing.T) {
+		parent := t.TempDir()
+		dest := filepath.Join(parent, "dest")
+		mod := Module{
+			Name:     "test",
+			Repo:     bare,
+			Revision: "main",
+			Dest:     dest,
+			Paths:    []Path{{Src: "src/lib", As: "lib"}},
+		}
+		if _, err := SyncModule(t.Context(), mod, nil, SyncOptions{}); err != nil {
+			t.Fatalf("SyncModule: %v", err)
+		}
+
+		mod.Paths = []Path{{Src: "docs"}, {Src: "src/lib", As: "lib", Exclude: []string{"["}}}
+		if _, err := SyncModule(t.Context(), mod, nil, SyncOptions{}); err == nil {
+			t.Fatal("expected error for invalid exclude pattern")
+		}
+
+		assertFileContent(t, filepath.Join(dest, "lib", "a.txt"), "aaa")
+		if _, err := os.Stat(filepath.Join(dest, "docs")); !os.IsNotExist(err) {
+			t.Errorf("expected docs to not be synced, got err: %v", err)
+		}
+		entries, err := os.ReadDir(parent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("expected only dest in parent dir, got %d entries", len(entries))
+		}
+	})
+
+	t.Run("unchanged files are not rewritten", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "dest")
+		mod := Module{
+			Name:     "test",
+			Repo:     bare,
+			Revision: "main",
+			Dest:     dest,
+			Paths:    []Path{{Src: "src/lib", As: "lib"}},
+		}
+		if _, err := SyncModule(t.Context(), mod, nil, SyncOptions{}); err != nil {
+			t.Fatalf("SyncModule: %v", err)
+		}
+		before, err := os.Stat(filepath.Join(dest, "lib", "a.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := SyncModule(t.Context(), mod, nil, SyncOptions{}); err != nil {
+			t.Fatalf("SyncModule: %v", err)
+		}
+		after, err := os.Stat(filepath.Join(dest, "lib", "a.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !os.SameFile(before, after) || !after.ModTime().Equal(before.ModTime()) {
+			t.Error("expected unchanged file to be left in place")
+		}
+	})
+
 	t.Run("multiple paths", func(t *testing.T) {
 		dest := filepath.Join(t.TempDir(), "dest")
 		mod := Module{
@@ -218,7 +661,7 @@ func TestSyncModule(t *testing.T) {
 				{Src: "docs"},
 			},
 		}
-		if err := SyncModule(mod, SyncOptions{}); err != nil {
+		if _, err := SyncModule(t.Context(), mod, nil, SyncOptions{}); err != nil {
 			t.Fatalf("SyncModule: %v", err)
 		}
 		assertFileContent(t, filepath.Join(dest, "lib", "a.txt"), "aaa")
@@ -226,6 +669,268 @@ func TestSyncModule(t *testing.T) {
 	})
 }
 
+func TestSyncAll(t *testing.T) {
+	t.Run("writes lock and reuses locked commit", func(t *testing.T) {
+		bare := setupBareRepo(t)
+		dest := filepath.Join(t.TempDir(), "dest")
+		lockFile := filepath.Join(t.TempDir(), LockFileName)
+		cfg := &Config{
+			Version: 1,
+			Modules: []Module{{
+				Name:     "test",
+				Repo:     bare,
+				Revision: "main",
+				Dest:     dest,
+				Paths:    []Path{{Src: "src/lib", As: "lib"}},
+			}},
+		}
+		opts := SyncOptions{LockFile: lockFile}
+
+		if err := SyncAll(t.Context(), cfg, opts); err != nil {
+			t.Fatalf("SyncAll: %v", err)
+		}
+		lock, err := LoadLock(lockFile)
+		if err != nil {
+			t.Fatalf("LoadLock: %v", err)
+		}
+		locked := lock.Find("test")
+		if locked == nil {
+			t.Fatal("expected lock entry for module")
+		}
+		if len(locked.Commit) != 40 {
+			t.Errorf("commit = %q, want a full commit SHA", locked.Commit)
+		}
+		if locked.Paths[0].Hash == "" {
+			t.Error("expected path hash to be recorded")
+		}
+
+		// Moving the branch upstream must not change the synced content.
+		pushCommit(t, bare, map[string]string{"src/lib/a.txt": "changed"})
+
+		if err := SyncAll(t.Context(), cfg, opts); err != nil {
+			t.Fatalf("SyncAll: %v", err)
+		}
+		assertFileContent(t, filepath.Join(dest, "lib", "a.txt"), "aaa")
+
+		relocked, err := LoadLock(lockFile)
+		if err != nil {
+			t.Fatalf("LoadLock: %v", err)
+		}
+		if relocked.Modules[0].Commit != locked.Commit {
+			t.Errorf("commit = %q, want %q", relocked.Modules[0].Commit, locked.Commit)
+		}
+	})
+
+	t.Run("records the tag a constraint resolved to", func(t *testing.T) {
+		bare := setupBareRepo(t)
+		gitTestOutput(t, bare, "tag", "v1.2.0", "main")
+		lockFile := filepath.Join(t.TempDir(), LockFileName)
+		cfg := &Config{
+			Version: 1,
+			Modules: []Module{{
+				Name:     "test",
+				Repo:     bare,
+				Revision: "^1.0",
+				Dest:     filepath.Join(t.TempDir(), "dest"),
+				Paths:    []Path{{Src: "src/lib"}},
+			}},
+		}
+		opts := SyncOptions{LockFile: lockFile}
+
+		for range 2 {
+			// The second sync checks out the locked commit and must keep the tag.
+			if err := SyncAll(t.Context(), cfg, opts); err != nil {
+				t.Fatalf("SyncAll: %v", err)
+			}
+			lock, err := LoadLock(lockFile)
+			if err != nil {
+				t.Fatalf("LoadLock: %v", err)
+			}
+			if got := lock.Find("test").Tag; got != "v1.2.0" {
+				t.Errorf("tag = %q, want %q", got, "v1.2.0")
+			}
+		}
+	})
+
+	t.Run("canceled sync leaves dest and lock untouched", func(t *testing.T) {
+		bare := setupBareRepo(t)
+		dest := filepath.Join(t.TempDir(), "dest")
+		lockFile := filepath.Join(t.TempDir(), LockFileName)
+		cfg := &Config{
+			Version: 1,
+			Modules: []Module{{
+				Name:     "test",
+				Repo:     bare,
+				Revision: "main",
+				Dest:     dest,
+				Paths:    []Path{{Src: "src/lib"}},
+			}},
+		}
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		err := SyncAll(ctx, cfg, SyncOptions{LockFile: lockFile})
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("err = %v, want context.Canceled", err)
+		}
+		for _, path := range []string{dest, lockFile} {
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Errorf("expected %s to not exist, got err: %v", path, err)
+			}
+		}
+	})
+
+	t.Run("keep-going syncs the other modules and reports every failure", func(t *testing.T) {
+		bare := setupBareRepo(t)
+		dir := t.TempDir()
+		lockFile := filepath.Join(dir, LockFileName)
+		cfg := &Config{
+			Version: 1,
+			Modules: []Module{
+				{Name: "missing", Repo: bare, Revision: "no-such-branch", Dest: filepath.Join(dir, "missing"), Paths: []Path{{Src: "src/lib"}}},
+				{Name: "good", Repo: bare, Revision: "main", Dest: filepath.Join(dir, "good"), Paths: []Path{{Src: "src/lib"}}},
+				{Name: "gone", Repo: filepath.Join(dir, "gone.git"), Revision: "main", Dest: filepath.Join(dir, "gone"), Paths: []Path{{Src: "src/lib"}}},
+			},
+		}
+
+		err := SyncAll(t.Context(), cfg, SyncOptions{LockFile: lockFile})
+		var stageErr *StageError
+		if !errors.As(err, &stageErr) || stageErr.Stage != StageFetch {
+			t.Fatalf("err = %v, want a fetch StageError", err)
+		}
+
+		err = SyncAll(t.Context(), cfg, SyncOptions{LockFile: lockFile, KeepGoing: true})
+		var failed ModuleErrors
+		if !errors.As(err, &failed) {
+			t.Fatalf("err = %v, want ModuleErrors", err)
+		}
+		if len(failed) != 2 || failed[0].Module != "missing" || failed[1].Module != "gone" {
+			t.Fatalf("failed = %v, want missing and gone", failed)
+		}
+		for _, e := range failed {
+			if e.Stage != StageFetch {
+				t.Errorf("[%s] stage = %q, want %q", e.Module, e.Stage, StageFetch)
+			}
+		}
+		assertFileContent(t, filepath.Join(dir, "good", "src", "lib", "a.txt"), "aaa")
+
+		lock, err := LoadLock(lockFile)
+		if err != nil {
+			t.Fatalf("LoadLock: %v", err)
+		}
+		if len(lock.Modules) != 1 || lock.Find("good") == nil {
+			t.Errorf("lock modules = %+v, want only good", lock.Modules)
+		}
+	})
+
+	t.Run("failure still saves the modules that completed", func(t *testing.T) {
+		// An ext:: remote that never answers fails its module only after the other one has synced.
+		t.Setenv("GIT_CONFIG_COUNT", "1")
+		t.Setenv("GIT_CONFIG_KEY_0", "protocol.ext.allow")
+		t.Setenv("GIT_CONFIG_VALUE_0", "always")
+		bare := setupBareRepo(t)
+		dir := t.TempDir()
+		lockFile := filepath.Join(dir, LockFileName)
+		good := Module{Name: "good", Repo: bare, Revision: "main", Dest: filepath.Join(dir, "good"), Paths: []Path{{Src: "src/lib"}}}
+		hung := Module{Name: "hung", Repo: "ext::sleep 30", Revision: "main", Dest: filepath.Join(dir, "hung"), Paths: []Path{{Src: "src/lib"}}, Timeout: 2 * time.Second}
+		hungEntry := LockedModule{Name: "hung", Repo: hung.Repo, Revision: "main", Commit: strings.Repeat("a", 40), Paths: []LockedPath{{Src: "src/lib"}}}
+		if err := (&Lock{Version: 1, Modules: []LockedModule{hungEntry}}).Save(lockFile); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg := &Config{Version: 1, Modules: []Module{hung, good}}
+		err := SyncAll(t.Context(), cfg, SyncOptions{LockFile: lockFile})
+		var stageErr *StageError
+		if !errors.As(err, &stageErr) || stageErr.Module != "hung" {
+			t.Fatalf("err = %v, want a StageError for hung", err)
+		}
+		assertFileContent(t, filepath.Join(dir, "good", "src", "lib", "a.txt"), "aaa")
+
+		lock, err := LoadLock(lockFile)
+		if err != nil {
+			t.Fatalf("LoadLock: %v", err)
+		}
+		if entry := lock.Find("good"); entry == nil || entry.Commit != gitTestOutput(t, bare, "rev-parse", "main") {
+			t.Errorf("good entry = %+v, want the synced commit", entry)
+		}
+		if entry := lock.Find("hung"); entry == nil || entry.Commit != hungEntry.Commit {
+			t.Errorf("hung entry = %+v, want the previous entry", entry)
+		}
+	})
+
+	t.Run("frozen requires lock file", func(t *testing.T) {
+		bare := setupBareRepo(t)
+		dest := filepath.Join(t.TempDir(), "dest")
+		cfg := &Config{
+			Version: 1,
+			Modules: []Module{{
+				Name:     "test",
+				Repo:     bare,
+				Revision: "main",
+				Dest:     dest,
+				Paths:    []Path{{Src: "src/lib"}},
+			}},
+		}
+
+		err := SyncAll(t.Context(), cfg, SyncOptions{LockFile: filepath.Join(t.TempDir(), LockFileName), Frozen: true})
+		if err == nil {
+			t.Fatal("expected error for missing lock file")
+		}
+		if _, err := os.Stat(dest); !os.IsNotExist(err) {
+			t.Errorf("expected dest dir to not exist, got err: %v", err)
+		}
+	})
+
+	t.Run("frozen fails when config changed", func(t *testing.T) {
+		bare := setupBareRepo(t)
+		lockFile := filepath.Join(t.TempDir(), LockFileName)
+		cfg := &Config{
+			Version: 1,
+			Modules: []Module{{
+				Name:     "test",
+				Repo:     bare,
+				Revision: "main",
+				Dest:     filepath.Join(t.TempDir(), "dest"),
+				Paths:    []Path{{Src: "src/lib"}},
+			}},
+		}
+
+		if err := SyncAll(t.Context(), cfg, SyncOptions{LockFile: lockFile}); err != nil {
+			t.Fatalf("SyncAll: %v", err)
+		}
+		if err := SyncAll(t.Context(), cfg, SyncOptions{LockFile: lockFile, Frozen: true}); err != nil {
+			t.Fatalf("SyncAll frozen: %v", err)
+		}
+
+		cfg.Modules[0].Paths = append(cfg.Modules[0].Paths, Path{Src: "docs"})
+		if err := SyncAll(t.Context(), cfg, SyncOptions{LockFile: lockFile, Frozen: true}); err == nil {
+			t.Fatal("expected error for out of date lock")
+		}
+	})
+
+	t.Run("dry-run does not write lock", func(t *testing.T) {
+		bare := setupBareRepo(t)
+		lockFile := filepath.Join(t.TempDir(), LockFileName)
+		cfg := &Config{
+			Version: 1,
+			Modules: []Module{{
+				Name:     "test",
+				Repo:     bare,
+				Revision: "main",
+				Dest:     filepath.Join(t.TempDir(), "dest"),
+				Paths:    []Path{{Src: "src/lib"}},
+			}},
+		}
+
+		if err := SyncAll(t.Context(), cfg, SyncOptions{DryRun: true, LockFile: lockFile}); err != nil {
+			t.Fatalf("SyncAll: %v", err)
+		}
+		if _, err := os.Stat(lockFile); !os.IsNotExist(err) {
+			t.Errorf("expected lock file to not exist in dry-run, got err: %v", err)
+		}
+	})
+}
+
 func assertFileContent(t *testing.T, path, want string) {
 	t.Helper()
 	got, err := os.ReadFile(path)
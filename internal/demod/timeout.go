@@ -0,0 +1,43 @@
+package demod
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TimeoutError is the cause of a canceled git command when a module sync or a single git command
+// exceeds its configured timeout.
+type TimeoutError struct {
+	// Op is what timed out, such as "module sync" or "git fetch".
+	Op      string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %s", e.Op, e.Timeout)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// moduleContext returns a context for processing mod that is canceled once mod.Timeout has elapsed,
+// and in which every git command is limited to mod.GitTimeout.
+func moduleContext(ctx context.Context, mod Module) (context.Context, context.CancelFunc) {
+	if mod.GitTimeout > 0 {
+		ctx = context.WithValue(ctx, gitTimeoutKey{}, mod.GitTimeout)
+	}
+	if mod.Timeout > 0 {
+		return context.WithTimeoutCause(ctx, mod.Timeout, &TimeoutError{Op: "module sync", Timeout: mod.Timeout})
+	}
+	return context.WithCancel(ctx)
+}
+
+type gitTimeoutKey struct{}
+
+// gitTimeout returns the time limit of a single git command set by moduleContext, or 0 if there is none.
+func gitTimeout(ctx context.Context) time.Duration {
+	d, _ := ctx.Value(gitTimeoutKey{}).(time.Duration)
+	return d
+}
@@ -0,0 +1,68 @@
+package demod
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestModuleContext(t *testing.T) {
+	// An ext:: remote that never answers stands in for an unresponsive server.
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "protocol.ext.allow")
+	t.Setenv("GIT_CONFIG_VALUE_0", "always")
+	hung := Module{
+		Name:     "hung",
+		Repo:     "ext::sleep 30",
+		Revision: "main",
+		Dest:     filepath.Join(t.TempDir(), "dest"),
+		Paths:    []Path{{Src: "src"}},
+	}
+
+	t.Run("module timeout", func(t *testing.T) {
+		mod := hung
+		mod.Timeout = 200 * time.Millisecond
+		start := time.Now()
+		_, err := SyncModule(t.Context(), mod, nil, SyncOptions{})
+		if elapsed := time.Since(start); elapsed > 10*time.Second {
+			t.Errorf("sync took %v, want it to stop at the timeout", elapsed)
+		}
+		assertTimeout(t, err, "module sync")
+	})
+
+	t.Run("git timeout", func(t *testing.T) {
+		mod := hung
+		mod.GitTimeout = 200 * time.Millisecond
+		opts := SyncOptions{Retry: RetryPolicy{Attempts: 2, InitialDelay: time.Millisecond}}
+		_, err := SyncModule(t.Context(), mod, nil, opts)
+		assertTimeout(t, err, "git ls-remote")
+	})
+
+	t.Run("no timeout", func(t *testing.T) {
+		ctx, cancel := moduleContext(t.Context(), Module{})
+		defer cancel()
+		if _, ok := ctx.Deadline(); ok {
+			t.Error("expected no deadline")
+		}
+		if d := gitTimeout(ctx); d != 0 {
+			t.Errorf("gitTimeout = %v, want 0", d)
+		}
+	})
+}
+
+func assertTimeout(t *testing.T, err error, op string) {
+	t.Helper()
+	var stageErr *StageError
+	if !errors.As(err, &stageErr) || stageErr.Module != "hung" || stageErr.Stage != StageFetch {
+		t.Fatalf("err = %v, want a fetch StageError for hung", err)
+	}
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Op != op {
+		t.Fatalf("err = %v, want a TimeoutError for %s", err, op)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %v to wrap context.DeadlineExceeded", err)
+	}
+}
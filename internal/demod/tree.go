@@ -0,0 +1,53 @@
+package demod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+// isContentDigest reports whether the tree pin of a path is a content digest rather than a git object ID.
+func isContentDigest(tree string) bool {
+	return strings.HasPrefix(tree, "sha256:")
+}
+
+// verifyTrees checks that the git tree of every module path pinned to a git object ID
+// matches the tree of its Src at commit in mirror.
+func verifyTrees(ctx context.Context, logger *slog.Logger, mirror, commit string, paths []Path) error {
+	var errs []error
+	for _, p := range paths {
+		if p.Tree == "" || isContentDigest(p.Tree) {
+			continue
+		}
+		rev := commit + "^{tree}"
+		if src := path.Clean(p.Src); src != "." {
+			rev = commit + ":" + src
+		}
+		out, err := gitOutput(ctx, logger, mirror, "rev-parse", "--verify", rev)
+		if err != nil {
+			return err
+		}
+		if got := strings.TrimSpace(out); got != p.Tree {
+			errs = append(errs, fmt.Errorf("tree of %s is %s, expected %s", p.Src, got, p.Tree))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// verifyDigests checks that the files copied for every module path pinned to a content digest
+// match it. The digest is the one recorded as the path hash in the lock file.
+func verifyDigests(manifest Manifest, paths []Path) error {
+	var errs []error
+	for _, p := range paths {
+		if !isContentDigest(p.Tree) {
+			continue
+		}
+		if got := manifest.hashPath(p.dest()); got != p.Tree {
+			errs = append(errs, fmt.Errorf("content of %s is %s, expected %s", p.Src, got, p.Tree))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,101 @@
+package demod
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifyTrees(t *testing.T) {
+	logger := slog.Default()
+	bare := setupBareRepo(t)
+	mirror := setupMirror(t, bare)
+	commit, _, err := fetchRevision(t.Context(), logger, mirror, "main")
+	if err != nil {
+		t.Fatalf("fetchRevision: %v", err)
+	}
+	libTree := gitTestOutput(t, bare, "rev-parse", "main:src/lib")
+	rootTree := gitTestOutput(t, bare, "rev-parse", "main^{tree}")
+
+	t.Run("matching trees", func(t *testing.T) {
+		paths := []Path{{Src: "src/lib", Tree: libTree}, {Src: ".", Tree: rootTree}, {Src: "docs"}}
+		if err := verifyTrees(t.Context(), logger, mirror, commit, paths); err != nil {
+			t.Errorf("verifyTrees: %v", err)
+		}
+	})
+
+	t.Run("mismatched tree", func(t *testing.T) {
+		paths := []Path{{Src: "src/lib/", Tree: rootTree}}
+		if err := verifyTrees(t.Context(), logger, mirror, commit, paths); err == nil {
+			t.Error("expected error for mismatched tree")
+		}
+	})
+
+	t.Run("content digests are skipped", func(t *testing.T) {
+		paths := []Path{{Src: "src/lib", Tree: "sha256:" + sumA}}
+		if err := verifyTrees(t.Context(), logger, mirror, commit, paths); err != nil {
+			t.Errorf("verifyTrees: %v", err)
+		}
+	})
+}
+
+func TestVerifyDigests(t *testing.T) {
+	manifest := Manifest{"lib/a.txt": sumA, "lib/b.txt": sumB}
+	digest := manifest.hashPath("lib")
+
+	if err := verifyDigests(manifest, []Path{{Src: "src/lib", As: "lib", Tree: digest}}); err != nil {
+		t.Errorf("verifyDigests: %v", err)
+	}
+	other := Manifest{"lib/a.txt": sumB, "lib/b.txt": sumB}
+	if err := verifyDigests(other, []Path{{Src: "src/lib", As: "lib", Tree: digest}}); err == nil {
+		t.Error("expected error for mismatched digest")
+	}
+}
+
+func TestSyncModule_TreePin(t *testing.T) {
+	bare := setupBareRepo(t)
+	mod := Module{
+		Name:     "test",
+		Repo:     bare,
+		Revision: "main",
+		Paths:    []Path{{Src: "src/lib", As: "lib", Tree: gitTestOutput(t, bare, "rev-parse", "main:src/lib")}},
+	}
+
+	mod.Dest = filepath.Join(t.TempDir(), "dest")
+	entry, err := SyncModule(t.Context(), mod, nil, SyncOptions{})
+	if err != nil {
+		t.Fatalf("SyncModule: %v", err)
+	}
+
+	t.Run("content digest from the lock", func(t *testing.T) {
+		mod := mod
+		mod.Dest = filepath.Join(t.TempDir(), "dest")
+		mod.Paths = []Path{{Src: "src/lib", As: "lib", Tree: entry.Paths[0].Hash}}
+		if _, err := SyncModule(t.Context(), mod, nil, SyncOptions{}); err != nil {
+			t.Fatalf("SyncModule: %v", err)
+		}
+	})
+
+	// Simulate upstream moving main to different content.
+	pushCommit(t, bare, map[string]string{"src/lib/a.txt": "tampered"})
+
+	t.Run("tree mismatch fails", func(t *testing.T) {
+		if _, err := SyncModule(t.Context(), mod, nil, SyncOptions{}); err == nil {
+			t.Fatal("expected error for mismatched tree")
+		}
+		assertFileContent(t, filepath.Join(mod.Dest, "lib", "a.txt"), "aaa")
+	})
+
+	t.Run("content digest mismatch fails", func(t *testing.T) {
+		mod := mod
+		mod.Dest = filepath.Join(t.TempDir(), "dest")
+		mod.Paths = []Path{{Src: "src/lib", As: "lib", Tree: entry.Paths[0].Hash}}
+		if _, err := SyncModule(t.Context(), mod, nil, SyncOptions{}); err == nil {
+			t.Fatal("expected error for mismatched content digest")
+		}
+		if _, err := os.Stat(mod.Dest); !os.IsNotExist(err) {
+			t.Errorf("expected dest dir to not exist, got err: %v", err)
+		}
+	})
+}
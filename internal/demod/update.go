@@ -0,0 +1,76 @@
+package demod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// LockChange describes how the locked commit of a module changed during an update.
+// From is empty if the module was not locked before.
+// Tag is the tag To was resolved from if the module revision is a semver constraint.
+type LockChange struct {
+	Name string
+	From string
+	To   string
+	Tag  string
+}
+
+// Update re-resolves the revisions of the named modules, or of all modules if names is empty,
+// then syncs every module and rewrites the lock file.
+// Modules that are not being updated stay at their locked commit.
+// If the sync fails, the changes of the modules that were updated before the failure are returned
+// along with the error.
+func Update(ctx context.Context, cfg *Config, names []string, opts SyncOptions) ([]LockChange, error) {
+	for _, name := range names {
+		if !slices.ContainsFunc(cfg.Modules, func(mod Module) bool { return mod.Name == name }) {
+			return nil, fmt.Errorf("unknown module: %s", name)
+		}
+	}
+
+	lock, err := loadLockOrEmpty(opts.LockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	updating := func(mod Module) bool {
+		return len(names) == 0 || slices.Contains(names, mod.Name)
+	}
+	pin := func(mod Module) *LockedModule {
+		if updating(mod) {
+			return nil
+		}
+		return lock.pinned(mod)
+	}
+
+	newLock, err := syncAll(ctx, cfg, lock, pin, opts)
+	if newLock == nil {
+		return nil, err
+	}
+	// Without KeepGoing, the error only names the module that failed first: the modules it canceled
+	// kept their entries from lock, so only the modules whose commit changed are known to have completed.
+	var failed ModuleErrors
+	failuresKnown := err == nil || errors.As(err, &failed)
+
+	var changes []LockChange
+	for _, mod := range cfg.Modules {
+		if !updating(mod) || failed.failed(mod.Name) {
+			continue
+		}
+		locked := newLock.Find(mod.Name)
+		if locked == nil {
+			continue
+		}
+		old := lock.Find(mod.Name)
+		if !failuresKnown && old != nil && old.Commit == locked.Commit {
+			continue
+		}
+		change := LockChange{Name: mod.Name, To: locked.Commit, Tag: locked.Tag}
+		if old != nil {
+			change.From = old.Commit
+		}
+		changes = append(changes, change)
+	}
+	return changes, err
+}
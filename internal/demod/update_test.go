@@ -0,0 +1,129 @@
+package demod
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpdate(t *testing.T) {
+	bare := setupBareRepo(t)
+	dir := t.TempDir()
+	lockFile := filepath.Join(dir, LockFileName)
+	cfg := &Config{
+		Version: 1,
+		Modules: []Module{
+			{
+				Name:     "foo",
+				Repo:     bare,
+				Revision: "main",
+				Dest:     filepath.Join(dir, "foo"),
+				Paths:    []Path{{Src: "src/lib", As: "lib"}},
+			},
+			{
+				Name:     "bar",
+				Repo:     bare,
+				Revision: "main",
+				Dest:     filepath.Join(dir, "bar"),
+				Paths:    []Path{{Src: "src/lib", As: "lib"}},
+			},
+		},
+	}
+	opts := SyncOptions{LockFile: lockFile}
+
+	if err := SyncAll(t.Context(), cfg, opts); err != nil {
+		t.Fatalf("SyncAll: %v", err)
+	}
+	before, err := LoadLock(lockFile)
+	if err != nil {
+		t.Fatalf("LoadLock: %v", err)
+	}
+	oldCommit := before.Find("foo").Commit
+
+	newCommit := pushCommit(t, bare, map[string]string{"src/lib/a.txt": "changed"})
+
+	t.Run("named module only", func(t *testing.T) {
+		changes, err := Update(t.Context(), cfg, []string{"foo"}, opts)
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		if len(changes) != 1 {
+			t.Fatalf("len(changes) = %d, want 1", len(changes))
+		}
+		if changes[0].Name != "foo" || changes[0].From != oldCommit || changes[0].To != newCommit {
+			t.Errorf("changes[0] = %+v, want foo %s → %s", changes[0], oldCommit, newCommit)
+		}
+
+		assertFileContent(t, filepath.Join(dir, "foo", "lib", "a.txt"), "changed")
+		assertFileContent(t, filepath.Join(dir, "bar", "lib", "a.txt"), "aaa")
+
+		lock, err := LoadLock(lockFile)
+		if err != nil {
+			t.Fatalf("LoadLock: %v", err)
+		}
+		if got := lock.Find("foo").Commit; got != newCommit {
+			t.Errorf("foo commit = %q, want %q", got, newCommit)
+		}
+		if got := lock.Find("bar").Commit; got != oldCommit {
+			t.Errorf("bar commit = %q, want %q", got, oldCommit)
+		}
+	})
+
+	t.Run("all modules", func(t *testing.T) {
+		changes, err := Update(t.Context(), cfg, nil, opts)
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		if len(changes) != 2 {
+			t.Fatalf("len(changes) = %d, want 2", len(changes))
+		}
+		assertFileContent(t, filepath.Join(dir, "bar", "lib", "a.txt"), "changed")
+	})
+
+	t.Run("lock save failure", func(t *testing.T) {
+		opts := opts
+		opts.LockFile = filepath.Join(dir, "missing", LockFileName)
+		opts.KeepGoing = true
+		broken := Module{Name: "broken", Repo: filepath.Join(dir, "missing.git"), Revision: "main", Dest: filepath.Join(dir, "broken"), Paths: []Path{{Src: "src"}}}
+		cfg := &Config{Version: 1, Modules: []Module{cfg.Modules[0], broken}}
+		_, err := Update(t.Context(), cfg, nil, opts)
+		var failed ModuleErrors
+		if !errors.As(err, &failed) || !failed.failed("broken") || !strings.Contains(err.Error(), "writing lock") {
+			t.Fatalf("err = %v, want the failure of broken and a lock write error", err)
+		}
+	})
+
+	t.Run("failure still reports the modules that completed", func(t *testing.T) {
+		// An ext:: remote that never answers fails its module only after the other one has synced.
+		t.Setenv("GIT_CONFIG_COUNT", "1")
+		t.Setenv("GIT_CONFIG_KEY_0", "protocol.ext.allow")
+		t.Setenv("GIT_CONFIG_VALUE_0", "always")
+		dir := t.TempDir()
+		lockFile := filepath.Join(dir, LockFileName)
+		good := Module{Name: "good", Repo: bare, Revision: "main", Dest: filepath.Join(dir, "good"), Paths: []Path{{Src: "src/lib"}}}
+		hung := Module{Name: "hung", Repo: "ext::sleep 30", Revision: "main", Dest: filepath.Join(dir, "hung"), Paths: []Path{{Src: "src/lib"}}, Timeout: 2 * time.Second}
+		goodEntry := LockedModule{Name: "good", Repo: bare, Revision: "main", Commit: oldCommit, Paths: []LockedPath{{Src: "src/lib"}}}
+		hungEntry := LockedModule{Name: "hung", Repo: hung.Repo, Revision: "main", Commit: strings.Repeat("a", 40), Paths: []LockedPath{{Src: "src/lib"}}}
+		if err := (&Lock{Version: 1, Modules: []LockedModule{goodEntry, hungEntry}}).Save(lockFile); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg := &Config{Version: 1, Modules: []Module{hung, good}}
+		changes, err := Update(t.Context(), cfg, nil, SyncOptions{LockFile: lockFile})
+		var stageErr *StageError
+		if !errors.As(err, &stageErr) || stageErr.Module != "hung" {
+			t.Fatalf("err = %v, want a StageError for hung", err)
+		}
+		if len(changes) != 1 || changes[0].Name != "good" || changes[0].From != oldCommit || changes[0].To != newCommit {
+			t.Errorf("changes = %+v, want good %s → %s", changes, oldCommit, newCommit)
+		}
+	})
+
+	t.Run("unknown module", func(t *testing.T) {
+		if _, err := Update(t.Context(), cfg, []string{"nonexistent"}, opts); err == nil {
+			t.Fatal("expected error for unknown module")
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package demod
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Mismatch lists the differences between a module dest and the manifest recorded when it was synced.
+// Paths are slash-separated and relative to the module dest.
+type Mismatch struct {
+	Module string
+	// Modified are files whose content does not match the recorded hash.
+	Modified []string
+	// Missing are recorded files that no longer exist.
+	Missing []string
+	// Paths are the destinations of module paths whose recorded hashes do not match the lock.
+	Paths []string
+}
+
+func (m Mismatch) empty() bool {
+	return len(m.Modified) == 0 && len(m.Missing) == 0 && len(m.Paths) == 0
+}
+
+// Verify hashes the files demod owns in every module dest and compares them with the manifest
+// written at sync time, and the manifest with the path hashes in the lock file. It does not use git or the
+// network. It returns the mismatches of every module that does not verify.
+func Verify(cfg *Config, opts SyncOptions) ([]Mismatch, error) {
+	lock, err := loadLockOrEmpty(opts.LockFile)
+	if err != nil {
+		return nil, err
+	}
+
+	mismatches := make([]Mismatch, len(cfg.Modules))
+	var g errgroup.Group
+	for i, mod := range cfg.Modules {
+		g.Go(func() error {
+			mismatch, err := verifyModule(mod, lock.Find(mod.Name), opts)
+			if err != nil {
+				return err
+			}
+			mismatches[i] = *mismatch
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	var failed []Mismatch
+	for _, m := range mismatches {
+		if !m.empty() {
+			failed = append(failed, m)
+		}
+	}
+	return failed, nil
+}
+
+func verifyModule(mod Module, locked *LockedModule, opts SyncOptions) (*Mismatch, error) {
+	logger := WithModule(opts.logger(), mod.Name)
+	logger.Info("verifying", "dest", mod.Dest)
+
+	manifest, err := readManifest(mod.Dest)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("[%s] no manifest in %s (run `demod sync` first)", mod.Name, mod.Dest)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[%s] %w", mod.Name, err)
+	}
+
+	mismatch := &Mismatch{Module: mod.Name}
+
+	if locked != nil {
+		for _, lp := range locked.Paths {
+			destPath := Path{Src: lp.Src, As: lp.As}.dest()
+			if manifest.hashPath(destPath) != lp.Hash {
+				mismatch.Paths = append(mismatch.Paths, filepath.ToSlash(destPath))
+			}
+		}
+	}
+
+	for _, name := range manifest.files() {
+		sum, err := fileDigest(filepath.Join(mod.Dest, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			mismatch.Missing = append(mismatch.Missing, name)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("[%s] hashing: %w", mod.Name, err)
+		}
+		if sum != manifest[name] {
+			mismatch.Modified = append(mismatch.Modified, name)
+		}
+	}
+
+	return mismatch, nil
+}
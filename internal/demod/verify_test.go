@@ -0,0 +1,124 @@
+package demod
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	setup := func(t *testing.T) (*Config, SyncOptions, string) {
+		t.Helper()
+		bare := setupBareRepo(t)
+		dir := t.TempDir()
+		dest := filepath.Join(dir, "dest")
+		cfg := &Config{
+			Version: 1,
+			Modules: []Module{{
+				Name:     "test",
+				Repo:     bare,
+				Revision: "main",
+				Dest:     dest,
+				Paths:    []Path{{Src: "src/lib", As: "lib"}},
+			}},
+		}
+		opts := SyncOptions{LockFile: filepath.Join(dir, LockFileName)}
+		if err := SyncAll(t.Context(), cfg, opts); err != nil {
+			t.Fatalf("SyncAll: %v", err)
+		}
+		return cfg, opts, dest
+	}
+
+	t.Run("clean", func(t *testing.T) {
+		cfg, opts, _ := setup(t)
+		mismatches, err := Verify(cfg, opts)
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if len(mismatches) != 0 {
+			t.Errorf("mismatches = %+v, want none", mismatches)
+		}
+	})
+
+	t.Run("unmanaged files are ignored", func(t *testing.T) {
+		cfg, opts, dest := setup(t)
+		if err := os.WriteFile(filepath.Join(dest, "BUILD.bazel"), []byte("build"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		mismatches, err := Verify(cfg, opts)
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if len(mismatches) != 0 {
+			t.Errorf("mismatches = %+v, want none", mismatches)
+		}
+	})
+
+	t.Run("hand-edited files", func(t *testing.T) {
+		cfg, opts, dest := setup(t)
+		if err := os.WriteFile(filepath.Join(dest, "lib", "a.txt"), []byte("edited"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove(filepath.Join(dest, "lib", "b.txt")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dest, "lib", "c.txt"), []byte("ccc"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		mismatches, err := Verify(cfg, opts)
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if len(mismatches) != 1 {
+			t.Fatalf("len(mismatches) = %d, want 1", len(mismatches))
+		}
+		m := mismatches[0]
+		if !slices.Equal(m.Modified, []string{"lib/a.txt"}) {
+			t.Errorf("modified = %v, want [lib/a.txt]", m.Modified)
+		}
+		if !slices.Equal(m.Missing, []string{"lib/b.txt"}) {
+			t.Errorf("missing = %v, want [lib/b.txt]", m.Missing)
+		}
+	})
+
+	t.Run("manifest does not match lock", func(t *testing.T) {
+		cfg, opts, dest := setup(t)
+		if err := os.WriteFile(filepath.Join(dest, "lib", "a.txt"), []byte("edited"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		manifest, err := readManifest(dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifest["lib/a.txt"], err = hashFile(filepath.Join(dest, "lib", "a.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := manifest.write(dest); err != nil {
+			t.Fatal(err)
+		}
+
+		mismatches, err := Verify(cfg, opts)
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if len(mismatches) != 1 {
+			t.Fatalf("len(mismatches) = %d, want 1", len(mismatches))
+		}
+		if !slices.Equal(mismatches[0].Paths, []string{"lib"}) {
+			t.Errorf("paths = %v, want [lib]", mismatches[0].Paths)
+		}
+	})
+
+	t.Run("missing manifest", func(t *testing.T) {
+		cfg, opts, dest := setup(t)
+		if err := os.Remove(filepath.Join(dest, ManifestFileName)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Verify(cfg, opts); err == nil {
+			t.Fatal("expected error for missing manifest")
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package demod
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+)
+
+// workspace holds the temporary files of one run over a set of modules.
+// Modules that share a repository share its mirror and, when they resolve to the same commit,
+// a single worktree with the union of their sparse paths, so a repository is fetched and
+// checked out once no matter how many modules it is vendored into.
+type workspace struct {
+	dir      string
+	cacheDir string
+	// paths maps a repository to the sparse paths of every module using it.
+	paths map[string][]string
+	// offline disables network access. Worktrees are not shared then, so that content missing
+	// from the cache is only reported for the modules that need it.
+	offline bool
+
+	mu        sync.Mutex
+	worktrees map[worktreeKey]string
+}
+
+type worktreeKey struct {
+	repo   string
+	commit string
+	// module is set if the worktree belongs to a single module.
+	module string
+}
+
+// newWorkspace creates a workspace in a new temporary directory for modules.
+// Mirrors are kept in the cache dir of opts, or in the workspace if there is none.
+func newWorkspace(opts SyncOptions, modules []Module) (*workspace, error) {
+	dir, err := os.MkdirTemp("", "demod-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating temp dir: %w", err)
+	}
+	w := &workspace{
+		dir:       dir,
+		cacheDir:  opts.cacheDir(dir),
+		paths:     make(map[string][]string),
+		offline:   opts.Offline,
+		worktrees: make(map[worktreeKey]string),
+	}
+	for _, mod := range modules {
+		for _, p := range mod.Paths {
+			w.paths[mod.Repo] = append(w.paths[mod.Repo], p.Src)
+		}
+	}
+	for repo, paths := range w.paths {
+		slices.Sort(paths)
+		w.paths[repo] = slices.Compact(paths)
+	}
+	return w, nil
+}
+
+// close removes the workspace with all its worktrees.
+func (w *workspace) close() {
+	_ = os.RemoveAll(w.dir)
+}
+
+// tempDir creates a new directory in the workspace.
+func (w *workspace) tempDir(pattern string) (string, error) {
+	return os.MkdirTemp(w.dir, pattern)
+}
+
+// checkout returns a worktree of mirror, the mirror of mod.Repo, at commit, creating it on first use.
+// The caller must hold the lock of mirror. The worktree must not be modified.
+// If it fails, it also returns the stage that failed.
+func (w *workspace) checkout(ctx context.Context, logger *slog.Logger, mirror string, mod Module, commit string) (string, string, error) {
+	key := worktreeKey{repo: mod.Repo, commit: commit}
+	paths := w.paths[mod.Repo]
+	if w.offline {
+		key.module = mod.Name
+		paths = make([]string, len(mod.Paths))
+		for i, p := range mod.Paths {
+			paths[i] = p.Src
+		}
+	}
+	w.mu.Lock()
+	workdir, ok := w.worktrees[key]
+	w.mu.Unlock()
+	if ok {
+		logger.Info("reusing checkout", "commit", commit)
+		return workdir, "", nil
+	}
+
+	workdir, err := w.tempDir("worktree-*")
+	if err != nil {
+		return "", StagePrepare, err
+	}
+	if err := gitWorktreeAdd(ctx, logger, mirror, workdir, commit); err != nil {
+		return "", StageSparseCheckout, err
+	}
+	if err := gitSparseCheckoutInit(ctx, logger, workdir); err != nil {
+		return "", StageSparseCheckout, err
+	}
+	if err := gitSparseCheckoutSet(ctx, logger, workdir, paths); err != nil {
+		return "", StageSparseCheckout, err
+	}
+	logger.Info("checkout", "commit", commit)
+	if err := gitCheckout(ctx, logger, workdir, commit); err != nil {
+		if w.offline && notCachedContent(err) {
+			return "", StageCheckout, fmt.Errorf("files of commit %s: %w", commit, ErrNotCached)
+		}
+		return "", StageCheckout, err
+	}
+
+	w.mu.Lock()
+	w.worktrees[key] = workdir
+	w.mu.Unlock()
+	return workdir, "", nil
+}
@@ -0,0 +1,76 @@
+package demod
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestNewWorkspace(t *testing.T) {
+	modules := []Module{
+		{Name: "a", Repo: "r1", Paths: []Path{{Src: "proto"}, {Src: "docs"}}},
+		{Name: "b", Repo: "r1", Paths: []Path{{Src: "proto", As: "p"}}},
+		{Name: "c", Repo: "r2", Paths: []Path{{Src: "lib"}}},
+	}
+	ws, err := newWorkspace(SyncOptions{}, modules)
+	if err != nil {
+		t.Fatalf("newWorkspace: %v", err)
+	}
+	defer ws.close()
+
+	if got, want := ws.paths["r1"], []string{"docs", "proto"}; !slices.Equal(got, want) {
+		t.Errorf("paths[r1] = %v, want %v", got, want)
+	}
+	if got, want := ws.paths["r2"], []string{"lib"}; !slices.Equal(got, want) {
+		t.Errorf("paths[r2] = %v, want %v", got, want)
+	}
+	if ws.cacheDir != filepath.Join(ws.dir, "cache") {
+		t.Errorf("cacheDir = %q, want it under the workspace", ws.cacheDir)
+	}
+
+	ws.close()
+	if _, err := os.Stat(ws.dir); !os.IsNotExist(err) {
+		t.Errorf("expected workspace to be removed, got err: %v", err)
+	}
+}
+
+func TestCheckoutModule_SharedWorktree(t *testing.T) {
+	logger := slog.Default()
+	bare := setupBareRepo(t)
+	oldCommit := gitTestOutput(t, bare, "rev-parse", "main")
+	pushCommit(t, bare, map[string]string{"src/lib/a.txt": "changed"})
+
+	lib := Module{Name: "lib", Repo: bare, Revision: "main", Paths: []Path{{Src: "src/lib"}}}
+	docs := Module{Name: "docs", Repo: bare, Revision: "main", Paths: []Path{{Src: "docs"}}}
+	ws, err := newWorkspace(SyncOptions{}, []Module{lib, docs})
+	if err != nil {
+		t.Fatalf("newWorkspace: %v", err)
+	}
+	defer ws.close()
+
+	libDir, _, _, err := checkoutModule(t.Context(), logger, lib, nil, ws)
+	if err != nil {
+		t.Fatalf("checkoutModule: %v", err)
+	}
+	docsDir, _, _, err := checkoutModule(t.Context(), logger, docs, nil, ws)
+	if err != nil {
+		t.Fatalf("checkoutModule: %v", err)
+	}
+	if libDir != docsDir {
+		t.Errorf("worktrees = %q, %q, want one shared worktree", libDir, docsDir)
+	}
+	// The shared worktree has the sparse paths of both modules.
+	assertFileContent(t, filepath.Join(libDir, "src", "lib", "a.txt"), "changed")
+	assertFileContent(t, filepath.Join(libDir, "docs", "readme.txt"), "readme")
+
+	pinnedDir, _, _, err := checkoutModule(t.Context(), logger, lib, &LockedModule{Commit: oldCommit}, ws)
+	if err != nil {
+		t.Fatalf("checkoutModule: %v", err)
+	}
+	if pinnedDir == libDir {
+		t.Error("expected a separate worktree for another commit")
+	}
+	assertFileContent(t, filepath.Join(pinnedDir, "src", "lib", "a.txt"), "aaa")
+}